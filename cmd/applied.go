@@ -16,11 +16,10 @@ limitations under the License.
 package cmd
 
 import (
-	"fmt"
+	"os"
 
 	"github.com/cscoding21/csmig/migrate"
 	"github.com/cscoding21/csmig/persistence"
-	"github.com/cscoding21/csmig/shared"
 	"github.com/spf13/cobra"
 )
 
@@ -30,23 +29,31 @@ var appliedCmd = &cobra.Command{
 	Short: "Output a list of applied migrations",
 	Long: `Applied migrations are those which have been run against the data source.  The "applied"
 	command will output a list of migrations which have run against the target data source.`,
-	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("Finding applied migrations...")
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, err := getFormat(cmd.Flags())
+		if err != nil {
+			return err
+		}
+
+		progress("Finding applied migrations...")
 
-		config := shared.GetTestConfig()
+		config, err := loadConfig()
+		if err != nil {
+			return err
+		}
 		strategy, err := persistence.GetPersistenceStrategy(config)
 		if err != nil {
-			panic(err)
+			return err
 		}
+		defer strategy.Close()
 
-		applied, err := migrate.FindAppliedMigrations(strategy)
+		applied, err := migrate.FindAppliedMigrations(cmd.Context(), strategy)
 		if err != nil {
-			panic(err)
+			return err
 		}
 
-		for _, a := range applied {
-			fmt.Println(a.Name, a.Description, a.AppliedOn)
-		}
+		records := newAppliedRecords(applied)
+		return writeOutput(os.Stdout, format, records, records)
 	},
 }
 
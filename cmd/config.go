@@ -0,0 +1,80 @@
+/*
+Copyright © 2024 Jeff Kody <jeph@cscoding.io>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"github.com/cscoding21/csmig/persistence"
+	"github.com/cscoding21/csmig/shared"
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/viper"
+)
+
+// ProjectConfigName the name of the project-local config file searched for in the working directory.
+const ProjectConfigName = "csmig.yaml"
+
+// setConfigDefaults registers a default for every config key.  Viper only maps CSMIG_* environment
+// variables onto keys it knows about, so every key is registered even when the default is empty.
+func setConfigDefaults() {
+	viper.SetDefault("generator_path", "migrations")
+	viper.SetDefault("generator_package", "migrations")
+	viper.SetDefault("implementation_name", "csmig")
+	viper.SetDefault("database_strategy_name", "surrealdb")
+	viper.SetDefault("database_strategy.name", "")
+	viper.SetDefault("database_strategy.host", "localhost")
+	viper.SetDefault("database_strategy.port", 9999)
+	viper.SetDefault("database_strategy.user", "")
+	viper.SetDefault("database_strategy.password", "")
+	viper.SetDefault("database_strategy.database", "")
+	viper.SetDefault("database_strategy.namespace", "")
+	viper.SetDefault("database_strategy.options", map[string]string{})
+	viper.SetDefault("lock.timeout", persistence.DefaultLockTimeout.String())
+	viper.SetDefault("lock.stale_after", persistence.DefaultLockStaleAfter.String())
+	viper.SetDefault("timeout", "0s")
+	viper.SetDefault("migration_timeout", "0s")
+	viper.SetDefault("hooks.before_all", []string{})
+	viper.SetDefault("hooks.after_all", []string{})
+	viper.SetDefault("hooks.before_each", []string{})
+	viper.SetDefault("hooks.after_each", []string{})
+	viper.SetDefault("hooks.on_error", []string{})
+}
+
+// loadConfig builds the migrator config from the resolved viper settings.  Values are taken from,
+// in decreasing order of precedence:
+//
+//  1. CSMIG_* environment variables, e.g. CSMIG_DATABASE_STRATEGY_HOST
+//  2. the file passed with --config
+//  3. csmig.yaml in the working directory
+//  4. .csmig.yaml in the user's home directory
+//  5. built in defaults
+//
+// Only the first config file found is read.
+func loadConfig() (shared.MigratorConfig, error) {
+	config := shared.MigratorConfig{}
+
+	err := viper.Unmarshal(&config, func(dc *mapstructure.DecoderConfig) {
+		dc.TagName = "yaml"
+	})
+	if err != nil {
+		return config, err
+	}
+
+	config.ManifestPath = viper.ConfigFileUsed()
+	if config.DBConfig.Name == "" {
+		config.DBConfig.Name = config.DatabaseStrategyName
+	}
+
+	return config, nil
+}
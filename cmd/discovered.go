@@ -16,10 +16,9 @@ limitations under the License.
 package cmd
 
 import (
-	"fmt"
+	"os"
 
 	"github.com/cscoding21/csmig/migrate"
-	"github.com/cscoding21/csmig/shared"
 	"github.com/spf13/cobra"
 )
 
@@ -30,15 +29,25 @@ var discoveredCmd = &cobra.Command{
 	Long: `Discovered migrations are files that have been created which contain the logic for
 	a migration version, including its "Up" and "Down" functions.  This command outputs all migrations
 	that have been created regardless of whether or not they have been run.`,
-	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("Finding discovered migrations...")
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, err := getFormat(cmd.Flags())
+		if err != nil {
+			return err
+		}
 
-		config := shared.GetTestConfig()
-		discovered := migrate.FindDiscoveredMigrationFiles(config)
+		progress("Finding discovered migrations...")
 
-		for _, d := range discovered {
-			fmt.Println(d.Name, d.Description)
+		config, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		discovered, err := migrate.FindDiscoveredMigrationFiles(config)
+		if err != nil {
+			return err
 		}
+
+		records := newMigrationRecords(discovered)
+		return writeOutput(os.Stdout, format, records, records)
 	},
 }
 
@@ -0,0 +1,70 @@
+/*
+Copyright © 2024 Jeff Kody <jeph@cscoding.io>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/cscoding21/csmig/generate"
+	"github.com/spf13/cobra"
+)
+
+// downCmd represents the down command
+var downCmd = &cobra.Command{
+	Use:   "down",
+	Short: "Roll back the most recently applied migration",
+	Long: `The "down" command compiles the project's migrations package and calls the "Down" function of
+	the most recently applied migration, then removes its version record.  Use --steps to roll back
+	several migrations, or --to to roll back every migration newer than a known good version.`,
+	Args: cobra.MatchAll(cobra.NoArgs, validSteps),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fmt.Println("Running migrations down...")
+
+		config, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		to, _ := cmd.Flags().GetString("to")
+		steps, _ := cmd.Flags().GetInt("steps")
+
+		return generate.RunMigrations(cmd.Context(), config, "down", "--to", to, "--steps", strconv.Itoa(steps))
+	},
+}
+
+// validSteps rejects a --steps flag below 1.  It runs with the argument checks so that a bad value is reported
+// as a usage error.
+func validSteps(cmd *cobra.Command, args []string) error {
+	steps, err := cmd.Flags().GetInt("steps")
+	if err != nil {
+		return err
+	}
+
+	if steps < 1 {
+		return fmt.Errorf("--steps must be at least 1, got %d", steps)
+	}
+
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(downCmd)
+
+	downCmd.Flags().String("to", "", "The name of the migration to roll back to.  It remains applied.")
+	downCmd.Flags().Int("steps", 1, "The number of migrations to roll back.")
+	downCmd.MarkFlagsMutuallyExclusive("to", "steps")
+}
@@ -0,0 +1,127 @@
+/*
+Copyright © 2024 Jeff Kody <jeph@cscoding.io>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cscoding21/csmig/migrate"
+	"github.com/cscoding21/csmig/persistence"
+	"github.com/cscoding21/csmig/shared"
+	"github.com/spf13/cobra"
+)
+
+// forceCmd represents the force command
+var forceCmd = &cobra.Command{
+	Use:   "force <name>",
+	Short: "Resolve a dirty migration after fixing the database by hand",
+	Long: `A migration that fails part way through is left dirty and csmig refuses to run until it is resolved.  This
+	happens to migrations with no_transaction set, in either direction, and to every migration on databases such
+	as MySQL that commit schema changes implicitly.  Elsewhere a failed migration is rolled back with its
+	transaction and never left dirty.  After checking the database, use --clean to remove the version record
+	when the migration's changes are not in place, or --applied to record the migration as applied when they are.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := args[0]
+		clean, _ := cmd.Flags().GetBool("clean")
+
+		config, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		strategy, err := persistence.GetPersistenceStrategy(config)
+		if err != nil {
+			return err
+		}
+		defer strategy.Close()
+
+		err = strategy.Lock(cmd.Context(), config.Lock.Timeout, config.Lock.StaleAfter)
+		if err != nil {
+			return err
+		}
+		defer strategy.Unlock()
+
+		//---only a dirty record is resolved, anything else would drop or overwrite a healthy one
+		err = checkForceable(cmd.Context(), strategy, name)
+		if err != nil {
+			return err
+		}
+
+		if clean {
+			fmt.Printf("Removing the version record of %s...\n", name)
+
+			err = strategy.Rollback(cmd.Context(), name)
+			if err != nil {
+				return err
+			}
+
+			return nil
+		}
+
+		fmt.Printf("Recording %s as applied...\n", name)
+
+		discovered, err := migrate.FindDiscoveredMigrationFiles(config)
+		if err != nil {
+			return err
+		}
+
+		for _, dm := range discovered {
+			if dm.Name == name {
+				err = strategy.Apply(cmd.Context(), migrate.NewAppliedMigration(dm, 0))
+				if err != nil {
+					return err
+				}
+
+				return nil
+			}
+		}
+
+		return fmt.Errorf("migration %s was not found in %s", name, config.GeneratorPath)
+	},
+}
+
+// checkForceable returns an error unless the named migration has a dirty version record
+func checkForceable(ctx context.Context, strategy shared.DatabaseStrategy, name string) error {
+	appliedMigrations, err := strategy.FindApplied(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, am := range appliedMigrations {
+		if am.Name != name {
+			continue
+		}
+
+		if !am.Dirty {
+			return fmt.Errorf("migration %s has been applied and is not dirty, there is nothing to resolve", name)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("migration %s has no version record to resolve", name)
+}
+
+func init() {
+	rootCmd.AddCommand(forceCmd)
+
+	forceCmd.Flags().Bool("clean", false, "Remove the version record so the migration runs again.")
+	forceCmd.Flags().Bool("applied", false, "Record the migration as applied.")
+	forceCmd.MarkFlagsMutuallyExclusive("clean", "applied")
+	forceCmd.MarkFlagsOneRequired("clean", "applied")
+}
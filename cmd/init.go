@@ -27,14 +27,34 @@ var initCmd = &cobra.Command{
 	Use:   "init",
 	Short: "Init sets up csmig for a project",
 	Long: `Init sets up csmig for a project.  By default, a directory named "migrations" will be 
-	created where migration assets will be generated.`,
-	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("init called")
+	created where migration assets will be generated, along with a csmig.yaml config file that
+	describes the database to migrate.  An existing setup is not overwritten unless --force is given.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fmt.Println("Initializing csmig...")
 
-		err := generate.Init()
+		dir, _ := cmd.Flags().GetString("dir")
+		pkg, _ := cmd.Flags().GetString("package")
+		strategy, _ := cmd.Flags().GetString("strategy")
+		force, _ := cmd.Flags().GetBool("force")
+
+		manifestPath := cfgFile
+		if manifestPath == "" {
+			manifestPath = ProjectConfigName
+		}
+
+		config, err := generate.NewProjectConfig(manifestPath, dir, pkg, strategy)
+		if err != nil {
+			return err
+		}
+
+		err = generate.Init(config, force)
 		if err != nil {
-			panic(err)
+			return err
 		}
+
+		fmt.Printf("\ncsmig initialized in %s, configuration written to %s\n", config.GeneratorPath, config.ManifestPath)
+
+		return nil
 	},
 }
 
@@ -50,4 +70,7 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	initCmd.Flags().StringP("dir", "d", "migrations", "The directory where the migrations framework will be installed.")
+	initCmd.Flags().StringP("package", "p", "", "The Go package name for generated migrations.  Defaults to the name of the directory.")
+	initCmd.Flags().StringP("strategy", "s", "surrealdb", "The persistence strategy used to record applied migrations.")
+	initCmd.Flags().BoolP("force", "f", false, "Overwrite an existing csmig setup.")
 }
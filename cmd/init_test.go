@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInitWithNewConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	manifestPath := filepath.Join(dir, "my.yaml")
+	migrationsDir := filepath.Join(dir, "migrations")
+
+	rootCmd.SetArgs([]string{"init", "--config", manifestPath, "--dir", migrationsDir, "--strategy", "sqlite"})
+	t.Cleanup(func() {
+		rootCmd.SetArgs(nil)
+		cfgFile = ""
+		configErr = nil
+	})
+
+	err := rootCmd.Execute()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []string{manifestPath, filepath.Join(migrationsDir, "runner.gen.go")} {
+		if _, err := os.Stat(p); err != nil {
+			t.Errorf("init should have written %s: %v", p, err)
+		}
+	}
+}
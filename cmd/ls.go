@@ -41,7 +41,7 @@ func init() {
 
 	// Cobra supports Persistent Flags which will work for this command
 	// and all subcommands, e.g.:
-	lsCmd.PersistentFlags().StringP("format", "f", "standard", "The output format for the lists.")
+	lsCmd.PersistentFlags().StringP("format", "f", FormatTable, "The output format for the lists: table, json, yaml or csv.")
 
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
@@ -19,7 +19,6 @@ import (
 	"fmt"
 
 	"github.com/cscoding21/csmig/generate"
-	"github.com/cscoding21/csmig/shared"
 	"github.com/spf13/cobra"
 )
 
@@ -29,19 +28,31 @@ var newCmd = &cobra.Command{
 	Short: "Create a new migration in the configured directory",
 	Long: `The "new" command generated the scaffold for a new migration version and writes it
 	to the configured directory.  It accepts an optional description to help developers understand
-	what the migration is intended to do.`,
-	Run: func(cmd *cobra.Command, args []string) {
+	what the migration is intended to do.  With --sql it creates a pair of up and down SQL files, or
+	SurrealQL files for SurrealDB projects, instead of a Go migration.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
 		fmt.Println("Creating new migration...")
 
 		message, _ := cmd.Flags().GetString("message")
-		config := shared.GetTestConfig()
+		sqlFiles, _ := cmd.Flags().GetBool("sql")
+		config, err := loadConfig()
+		if err != nil {
+			return err
+		}
 
-		mig, err := generate.NewMigration(config, message)
+		newMigration := generate.NewMigration
+		if sqlFiles {
+			newMigration = generate.NewSQLMigration
+		}
+
+		mig, err := newMigration(config, message)
 		if err != nil {
-			panic(err)
+			return err
 		}
 
 		fmt.Println("Migration created: ", mig.Name)
+
+		return nil
 	},
 }
 
@@ -57,4 +68,5 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	newCmd.Flags().StringP("message", "m", "", "A description of the migration's general purpose.")
+	newCmd.Flags().Bool("sql", false, "Create plain SQL up and down files instead of a Go migration.")
 }
@@ -0,0 +1,192 @@
+/*
+Copyright © 2024 Jeff Kody <jeph@cscoding.io>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cscoding21/csmig/shared"
+	"gopkg.in/yaml.v3"
+)
+
+// Output formats supported by the listing commands.  The json and yaml documents and the csv and table columns
+// share one schema, named by the json tags of the record types below.
+const (
+	FormatTable = "table"
+	FormatJSON  = "json"
+	FormatYAML  = "yaml"
+	FormatCSV   = "csv"
+
+	//---the original name of the table format
+	FormatStandard = "standard"
+)
+
+// migrationRecord a discovered migration as printed by "ls discovered".
+type migrationRecord struct {
+	Name        string `json:"name" yaml:"name"`
+	Description string `json:"description" yaml:"description"`
+	FilePath    string `json:"file_path" yaml:"file_path"`
+	Checksum    string `json:"checksum" yaml:"checksum"`
+}
+
+// appliedRecord an applied migration as printed by "ls applied".
+type appliedRecord struct {
+	Name        string    `json:"name" yaml:"name"`
+	Description string    `json:"description" yaml:"description"`
+	AppliedOn   time.Time `json:"applied_on" yaml:"applied_on"`
+	Checksum    string    `json:"checksum" yaml:"checksum"`
+	Dirty       bool      `json:"dirty" yaml:"dirty"`
+	ExecutionMS int64     `json:"execution_ms" yaml:"execution_ms"`
+	AppliedBy   string    `json:"applied_by" yaml:"applied_by"`
+	Hostname    string    `json:"hostname" yaml:"hostname"`
+	ToolVersion string    `json:"tool_version" yaml:"tool_version"`
+}
+
+func newMigrationRecords(migrations []shared.Migration) []migrationRecord {
+	out := []migrationRecord{}
+	for _, m := range migrations {
+		out = append(out, migrationRecord{
+			Name:        m.Name,
+			Description: m.Description,
+			FilePath:    m.FilePath,
+			Checksum:    m.Checksum,
+		})
+	}
+
+	return out
+}
+
+func newAppliedRecords(applied []shared.AppliedMigration) []appliedRecord {
+	out := []appliedRecord{}
+	for _, a := range applied {
+		out = append(out, appliedRecord{
+			Name:        a.Name,
+			Description: a.Description,
+			AppliedOn:   a.AppliedOn,
+			Checksum:    a.Checksum,
+			Dirty:       a.Dirty,
+			ExecutionMS: a.ExecutionMS,
+			AppliedBy:   a.AppliedBy,
+			Hostname:    a.Hostname,
+			ToolVersion: a.ToolVersion,
+		})
+	}
+
+	return out
+}
+
+// getFormat returns the validated --format flag of a command.
+func getFormat(flags interface{ GetString(string) (string, error) }) (string, error) {
+	format, err := flags.GetString("format")
+	if err != nil {
+		return "", err
+	}
+
+	switch format {
+	case FormatStandard, "":
+		return FormatTable, nil
+	case FormatTable, FormatJSON, FormatYAML, FormatCSV:
+		return format, nil
+	}
+
+	return "", fmt.Errorf("unknown format %q, expected one of table, json, yaml or csv", format)
+}
+
+// progress prints a progress message to stderr so that it doesn't mix with machine readable output.
+func progress(message string) {
+	fmt.Fprintln(os.Stderr, message)
+}
+
+// writeOutput writes document as json or yaml, or rows as csv or an aligned table.  Rows are structs whose
+// json tags name the columns.
+func writeOutput[T any](w io.Writer, format string, document interface{}, rows []T) error {
+	switch format {
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(document)
+	case FormatYAML:
+		encoder := yaml.NewEncoder(w)
+		encoder.SetIndent(2)
+		err := encoder.Encode(document)
+		if err != nil {
+			return err
+		}
+		return encoder.Close()
+	case FormatCSV:
+		writer := csv.NewWriter(w)
+		writer.Write(recordColumns[T]())
+		for _, row := range rows {
+			writer.Write(recordValues(row))
+		}
+		writer.Flush()
+		return writer.Error()
+	}
+
+	writer := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, strings.ToUpper(strings.Join(recordColumns[T](), "\t")))
+	for _, row := range rows {
+		fmt.Fprintln(writer, strings.Join(recordValues(row), "\t"))
+	}
+
+	return writer.Flush()
+}
+
+func recordColumns[T any]() []string {
+	t := reflect.TypeFor[T]()
+
+	out := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		out = append(out, name)
+	}
+
+	return out
+}
+
+func recordValues(row interface{}) []string {
+	v := reflect.ValueOf(row)
+
+	out := []string{}
+	for i := 0; i < v.NumField(); i++ {
+		switch value := v.Field(i).Interface().(type) {
+		case time.Time:
+			if value.IsZero() {
+				out = append(out, "")
+			} else {
+				out = append(out, value.Format(time.RFC3339Nano))
+			}
+		case *time.Time:
+			if value == nil {
+				out = append(out, "")
+			} else {
+				out = append(out, value.Format(time.RFC3339Nano))
+			}
+		default:
+			out = append(out, fmt.Sprint(value))
+		}
+	}
+
+	return out
+}
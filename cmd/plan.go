@@ -0,0 +1,91 @@
+/*
+Copyright © 2024 Jeff Kody <jeph@cscoding.io>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/cscoding21/csmig/generate"
+	"github.com/cscoding21/csmig/shared"
+	"github.com/spf13/cobra"
+)
+
+// planCmd represents the plan command
+var planCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Show the migrations that up or down would run without changing the database",
+	Long: `The "plan" command compares the migrations in the catalog with those applied to the database
+	and prints, in order, the migrations that "up" would apply.  With --down it prints the migrations
+	that "down" would roll back.  Where possible the statements each migration passes to Exec are
+	captured by running it against a recording strategy and are printed beneath it.`,
+	Args: cobra.MatchAll(cobra.NoArgs, validSteps),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		down, _ := cmd.Flags().GetBool("down")
+		to, _ := cmd.Flags().GetString("to")
+		steps, _ := cmd.Flags().GetInt("steps")
+
+		plan, err := generate.PlanMigrations(cmd.Context(), config, down, to, steps)
+		if err != nil {
+			return err
+		}
+
+		printPlan(plan)
+
+		return nil
+	},
+}
+
+func printPlan(plan []shared.PlanStep) {
+	fmt.Println()
+	fmt.Println("--------------- CSMig Plan ---------------")
+
+	if len(plan) == 0 {
+		fmt.Println("Nothing to do, the database is up to date.")
+		return
+	}
+
+	for i, step := range plan {
+		fmt.Printf("%d. %-4s %s : %s\n", i+1, step.Direction, step.Name, step.Description)
+
+		for _, s := range step.Statements {
+			fmt.Printf("     > %s\n", strings.Join(strings.Fields(s.Statement), " "))
+			if len(s.Params) > 0 {
+				params, _ := json.Marshal(s.Params)
+				fmt.Printf("       params: %s\n", params)
+			}
+		}
+
+		if step.Warning != "" {
+			fmt.Printf("     ! %s\n", step.Warning)
+		}
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(planCmd)
+
+	planCmd.Flags().Bool("down", false, "Plan a rollback rather than an apply.")
+	planCmd.Flags().String("to", "", "The target migration, as for the up and down commands.")
+	planCmd.Flags().Int("steps", 1, "The number of migrations a rollback would undo.")
+	planCmd.MarkFlagsMutuallyExclusive("to", "steps")
+}
@@ -0,0 +1,45 @@
+/*
+Copyright © 2024 Jeff Kody <jeph@cscoding.io>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/cscoding21/csmig/generate"
+	"github.com/spf13/cobra"
+)
+
+// redoCmd represents the redo command
+var redoCmd = &cobra.Command{
+	Use:   "redo",
+	Short: "Roll back and re-apply the most recently applied migration",
+	Long: `The "redo" command rolls back the most recently applied migration and applies it again.  It is
+	useful while iterating on a migration during development.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fmt.Println("Running migrations redo...")
+
+		config, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		return generate.RunMigrations(cmd.Context(), config, "redo")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(redoCmd)
+}
@@ -19,7 +19,6 @@ import (
 	"fmt"
 
 	"github.com/cscoding21/csmig/generate"
-	"github.com/cscoding21/csmig/shared"
 	"github.com/spf13/cobra"
 )
 
@@ -28,16 +27,16 @@ var removeCmd = &cobra.Command{
 	Use:   "remove",
 	Short: "Remove a discovered migration as long as it has not been applied",
 	Long:  `The "remove" command deletes a migration file`,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		fmt.Println("remove called")
 
 		name, _ := cmd.Flags().GetString("name")
-		config := shared.GetTestConfig()
-
-		err := generate.RemoveMigration(config, name)
+		config, err := loadConfig()
 		if err != nil {
-			panic(err)
+			return err
 		}
+
+		return generate.RemoveMigration(config, name)
 	},
 }
 
@@ -16,15 +16,28 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
+	"github.com/cscoding21/csmig/shared"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
 var cfgFile string
 
+// configErr the error reading the config file, returned once the command runs.
+var configErr error
+
+// commandStarted set once flags and arguments have been validated.  Errors before then are usage errors.
+var commandStarted bool
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "csmig",
@@ -38,17 +51,48 @@ to quickly create a Cobra application.`,
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	// Run: func(cmd *cobra.Command, args []string) { },
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		//---the command line is valid, so a failure from here on doesn't need the usage text
+		commandStarted = true
+		cmd.SilenceUsage = true
+
+		//---init writes the config file, so a file named with --config doesn't have to exist yet
+		if cmd == initCmd && errors.Is(configErr, fs.ErrNotExist) {
+			return nil
+		}
+
+		return configErr
+	},
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
-// This is called by main.main(). It only needs to happen once to the rootCmd.
+// This is called by main.main(). It only needs to happen once to the rootCmd.  The exit code identifies
+// the kind of failure, see the shared.Exit* constants.
 func Execute() {
-	err := rootCmd.Execute()
+	//---the first Ctrl-C cancels the command's context so a run can stop cleanly, a second one exits immediately
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	err := rootCmd.ExecuteContext(ctx)
 	if err != nil {
-		os.Exit(1)
+		os.Exit(exitCode(err))
 	}
 }
 
+// exitCode returns the exit code for an error returned by a command.
+func exitCode(err error) int {
+	if !commandStarted {
+		return shared.ExitUsage
+	}
+
+	return shared.ExitCode(err)
+}
+
 func init() {
 	cobra.OnInitialize(initConfig)
 
@@ -56,7 +100,7 @@ func init() {
 	// Cobra supports persistent flags, which, if defined here,
 	// will be global for your application.
 
-	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.csmig.yaml)")
+	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is ./csmig.yaml, then $HOME/.csmig.yaml)")
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
@@ -65,13 +109,21 @@ func init() {
 
 // initConfig reads in config file and ENV variables if set.
 func initConfig() {
+	setConfigDefaults()
+
 	if cfgFile != "" {
 		// Use config file from the flag.
 		viper.SetConfigFile(cfgFile)
+	} else if _, err := os.Stat(ProjectConfigName); err == nil {
+		// Use the project config in the working directory.
+		viper.SetConfigFile(ProjectConfigName)
 	} else {
 		// Find home directory.
 		home, err := os.UserHomeDir()
-		cobra.CheckErr(err)
+		if err != nil {
+			configErr = err
+			return
+		}
 
 		// Search config in home directory with name ".csmig" (without extension).
 		viper.AddConfigPath(home)
@@ -79,10 +131,16 @@ func initConfig() {
 		viper.SetConfigName(".csmig")
 	}
 
-	viper.AutomaticEnv() // read in environment variables that match
+	// read in environment variables that match, e.g. CSMIG_DATABASE_STRATEGY_HOST for database_strategy.host
+	viper.SetEnvPrefix("csmig")
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	viper.AutomaticEnv()
 
-	// If a config file is found, read it in.
-	if err := viper.ReadInConfig(); err == nil {
+	// If a config file is found, read it in.  A file named with --config must exist.
+	err := viper.ReadInConfig()
+	if err == nil {
 		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
+	} else if cfgFile != "" {
+		configErr = err
 	}
 }
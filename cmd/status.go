@@ -17,6 +17,10 @@ package cmd
 
 import (
 	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/cscoding21/csmig/migrate"
 	"github.com/cscoding21/csmig/persistence"
@@ -29,39 +33,165 @@ import (
 var statusCmd = &cobra.Command{
 	Use:   "status",
 	Short: "Outputs a status of the migration configuration for this project",
-	Long: `A longer description that spans multiple lines and likely contains examples
-and usage of using your command. For example:
-
-Cobra is a CLI library for Go that empowers applications.
-This application is a tool to generate the needed files
-to quickly create a Cobra application.`,
-	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("Migration status...")
-
-		config := shared.GetTestConfig()
-		strategy, _ := persistence.GetPersistenceStrategy(config)
-
-		discoverd := migrate.FindDiscoveredMigrationFiles(config)
-		applied, _ := migrate.FindAppliedMigrations(strategy)
-
-		fmt.Println("--------------- CSMig Status ---------------")
-		fmt.Println("CSMig Version: ", version.Version)
-		fmt.Println("Migrations Directory: ", config.GetMigrationPath())
-		fmt.Println("Persistence Strategy: ", config.DatabaseStrategyName)
-		fmt.Println("---")
-		fmt.Println("Discovered Migrations: ")
-		for _, d := range discoverd {
-			fmt.Println("  - ", d.Name)
+	Long: `Status lists every migration that has been discovered in the migrations directory or applied to the
+database, along with its state:
+
+  applied   discovered and recorded in the version table
+  pending   discovered but not yet applied
+  orphaned  applied but with no matching migration file
+  dirty     a run failed part way, resolve it with "csmig force"
+
+Pending migrations older than the latest applied one are flagged as out of order.  Status exits
+with code 8 when pending or orphaned migrations exist, so CI can gate a deploy on it and tell an out of
+date database from a failure to check it.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, err := getFormat(cmd.Flags())
+		if err != nil {
+			return err
+		}
+
+		progress("Migration status...")
+
+		config, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		strategy, err := persistence.GetPersistenceStrategy(config)
+		if err != nil {
+			return err
+		}
+		defer strategy.Close()
+
+		discoverd, err := migrate.FindDiscoveredMigrationFiles(config)
+		if err != nil {
+			return err
+		}
+
+		applied, err := migrate.FindAppliedMigrations(cmd.Context(), strategy)
+		if err != nil {
+			return err
 		}
 
-		fmt.Println("---")
-		fmt.Println("Applied Migrations: ")
-		for _, a := range applied {
-			fmt.Printf("  - %s (%s) : %s \n", a.Name, a.AppliedOn, a.Description)
+		pending := migrate.FindPendingMigrations(discoverd, applied)
+		orphaned := migrate.FindOrphanedMigrations(discoverd, applied)
+		outOfOrder := migrate.FindOutOfOrderMigrations(discoverd, applied)
+
+		report := statusReport{
+			CSMigVersion:        version.Version,
+			MigrationsDirectory: config.GetMigrationPath(),
+			PersistenceStrategy: config.DatabaseStrategyName,
+			Pending:             len(pending),
+			Orphaned:            len(orphaned),
+			OutOfOrder:          len(outOfOrder),
+			Migrations:          newStatusRecords(discoverd, applied, outOfOrder),
 		}
+
+		if format == FormatTable {
+			fmt.Println("--------------- CSMig Status ---------------")
+			fmt.Println("CSMig Version: ", report.CSMigVersion)
+			fmt.Println("Migrations Directory: ", report.MigrationsDirectory)
+			fmt.Println("Persistence Strategy: ", report.PersistenceStrategy)
+			fmt.Println("---")
+		}
+
+		err = writeOutput(os.Stdout, format, report, report.Migrations)
+		if err != nil {
+			return err
+		}
+
+		if format == FormatTable {
+			fmt.Println("---")
+			fmt.Printf("Pending: %d (%d out of order)\n", report.Pending, report.OutOfOrder)
+			fmt.Printf("Orphaned: %d\n", report.Orphaned)
+		}
+
+		//---a non-zero exit code lets CI gate a deploy on an up to date database
+		if report.Pending > 0 || report.Orphaned > 0 {
+			return fmt.Errorf("%w: %d pending and %d orphaned migration(s)", shared.ErrOutOfDate, report.Pending, report.Orphaned)
+		}
+
+		return nil
 	},
 }
 
+// The states a migration can be in on the status report.
+const (
+	StateApplied  = "applied"
+	StatePending  = "pending"
+	StateOrphaned = "orphaned"
+	StateDirty    = "dirty"
+)
+
+// statusReport the document printed by "status".
+type statusReport struct {
+	CSMigVersion        string         `json:"csmig_version" yaml:"csmig_version"`
+	MigrationsDirectory string         `json:"migrations_directory" yaml:"migrations_directory"`
+	PersistenceStrategy string         `json:"persistence_strategy" yaml:"persistence_strategy"`
+	Pending             int            `json:"pending" yaml:"pending"`
+	Orphaned            int            `json:"orphaned" yaml:"orphaned"`
+	OutOfOrder          int            `json:"out_of_order" yaml:"out_of_order"`
+	Migrations          []statusRecord `json:"migrations" yaml:"migrations"`
+}
+
+// statusRecord a migration that has been discovered, applied or both.
+type statusRecord struct {
+	Name        string     `json:"name" yaml:"name"`
+	Description string     `json:"description" yaml:"description"`
+	State       string     `json:"state" yaml:"state"`
+	OutOfOrder  bool       `json:"out_of_order" yaml:"out_of_order"`
+	Discovered  bool       `json:"discovered" yaml:"discovered"`
+	Applied     bool       `json:"applied" yaml:"applied"`
+	AppliedOn   *time.Time `json:"applied_on" yaml:"applied_on"`
+	Dirty       bool       `json:"dirty" yaml:"dirty"`
+	ExecutionMS int64      `json:"execution_ms" yaml:"execution_ms"`
+	AppliedBy   string     `json:"applied_by" yaml:"applied_by"`
+	Hostname    string     `json:"hostname" yaml:"hostname"`
+	ToolVersion string     `json:"tool_version" yaml:"tool_version"`
+}
+
+// newStatusRecords joins the discovered and applied migrations into one record per migration, ordered by name.
+func newStatusRecords(discovered []shared.Migration, applied []shared.AppliedMigration, outOfOrder []shared.Migration) []statusRecord {
+	out := []statusRecord{}
+	for _, d := range discovered {
+		out = append(out, statusRecord{
+			Name:        d.Name,
+			Description: d.Description,
+			State:       StatePending,
+			OutOfOrder:  slices.ContainsFunc(outOfOrder, func(m shared.Migration) bool { return m.Name == d.Name }),
+			Discovered:  true,
+		})
+	}
+
+	for _, a := range applied {
+		i := slices.IndexFunc(out, func(r statusRecord) bool { return r.Name == a.Name })
+		if i < 0 {
+			out = append(out, statusRecord{Name: a.Name, Description: a.Description, State: StateOrphaned})
+			i = len(out) - 1
+		} else {
+			out[i].State = StateApplied
+		}
+
+		if a.Dirty {
+			out[i].State = StateDirty
+		}
+
+		appliedOn := a.AppliedOn
+		out[i].Applied = true
+		out[i].AppliedOn = &appliedOn
+		out[i].Dirty = a.Dirty
+		out[i].ExecutionMS = a.ExecutionMS
+		out[i].AppliedBy = a.AppliedBy
+		out[i].Hostname = a.Hostname
+		out[i].ToolVersion = a.ToolVersion
+	}
+
+	slices.SortFunc(out, func(a, b statusRecord) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return out
+}
+
 func init() {
 	rootCmd.AddCommand(statusCmd)
 
@@ -73,5 +203,5 @@ func init() {
 
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
-	// statusCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	statusCmd.Flags().StringP("format", "f", FormatTable, "The output format: table, json, yaml or csv.")
 }
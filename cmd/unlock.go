@@ -0,0 +1,52 @@
+/*
+Copyright © 2024 Jeff Kody <jeph@cscoding.io>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/cscoding21/csmig/persistence"
+	"github.com/spf13/cobra"
+)
+
+// unlockCmd represents the unlock command
+var unlockCmd = &cobra.Command{
+	Use:   "unlock",
+	Short: "Release the migration lock left behind by a crashed run",
+	Long: `The "unlock" command releases the migration lock regardless of which process holds it.  Use it
+	only after making sure no other run is in progress.  For postgres and mysql the database session
+	holding the advisory lock is terminated.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fmt.Println("Releasing the migration lock...")
+
+		config, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		strategy, err := persistence.GetPersistenceStrategy(config)
+		if err != nil {
+			return err
+		}
+		defer strategy.Close()
+
+		return strategy.ForceUnlock(cmd.Context())
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(unlockCmd)
+}
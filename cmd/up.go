@@ -0,0 +1,50 @@
+/*
+Copyright © 2024 Jeff Kody <jeph@cscoding.io>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/cscoding21/csmig/generate"
+	"github.com/spf13/cobra"
+)
+
+// upCmd represents the up command
+var upCmd = &cobra.Command{
+	Use:   "up",
+	Short: "Apply all pending migrations to the configured database",
+	Long: `The "up" command compiles the project's migrations package and applies every migration that
+	has not been applied yet, in order.  The Go toolchain must be installed and csmig must be run from
+	within the module that contains the migrations.  Use --to to stop after a specific migration.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fmt.Println("Running migrations up...")
+
+		config, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		to, _ := cmd.Flags().GetString("to")
+
+		return generate.RunMigrations(cmd.Context(), config, "up", "--to", to)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(upCmd)
+
+	upCmd.Flags().String("to", "", "The name of the last migration to apply.  Pending migrations after it are left unapplied.")
+}
@@ -0,0 +1,73 @@
+/*
+Copyright © 2024 Jeff Kody <jeph@cscoding.io>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/cscoding21/csmig/migrate"
+	"github.com/cscoding21/csmig/persistence"
+	"github.com/spf13/cobra"
+)
+
+// verifyCmd represents the verify command
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Report applied migrations whose source has changed since they were applied",
+	Long: `The "verify" command compares the checksum of each migration file with the checksum recorded
+	when the migration was applied.  It exits with an error when any applied migration has been edited.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fmt.Println("Verifying applied migrations...")
+
+		config, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		strategy, err := persistence.GetPersistenceStrategy(config)
+		if err != nil {
+			return err
+		}
+		defer strategy.Close()
+
+		applied, err := migrate.FindAppliedMigrations(cmd.Context(), strategy)
+		if err != nil {
+			return err
+		}
+
+		discovered, err := migrate.FindDiscoveredMigrationFiles(config)
+		if err != nil {
+			return err
+		}
+
+		mismatches := migrate.FindChecksumMismatches(discovered, applied)
+		for _, m := range mismatches {
+			fmt.Printf("  - %s changed after it was applied (recorded %s, current %s)\n", m.Name, m.Recorded, m.Current)
+		}
+
+		if len(mismatches) > 0 {
+			return fmt.Errorf("%d applied migration(s) have changed", len(mismatches))
+		}
+
+		fmt.Println("All applied migrations match their source")
+
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+}
@@ -1,10 +1,15 @@
 package generate
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"io/fs"
 	"os"
 	"path"
+	"path/filepath"
+	"slices"
+	"strings"
 
 	"github.com/cscoding21/csgen"
 	"github.com/cscoding21/csmig/migrate"
@@ -12,17 +17,28 @@ import (
 	"github.com/cscoding21/csmig/shared"
 )
 
-func Init() error {
-	//---create an initial manifest it doesn't exist
-	config := shared.MigratorConfig{
-		GeneratorPath:        "migrations",
-		GeneratorPackage:     "migrations",
-		DatabaseStrategyName: "surrealdb",
-	}
+// Init sets up csmig for a project.  It creates the migrations directory with the runner and an empty
+// catalog, and writes a commented config manifest to config.ManifestPath.  An existing setup is left
+// untouched unless force is true.
+func Init(config shared.MigratorConfig, force bool) error {
 	migrationsDir := path.Join(config.GeneratorPath)
+	runnerPath := path.Join(migrationsDir, "runner.gen.go")
+
+	if !force {
+		for _, p := range []string{config.ManifestPath, runnerPath} {
+			if _, err := os.Stat(p); err == nil {
+				return fmt.Errorf("csmig is already initialized, %s exists (use force to overwrite)", p)
+			}
+		}
+	}
+
+	err := os.MkdirAll(migrationsDir, 0755)
+	if err != nil {
+		return err
+	}
 
 	//---create or overwrite the runner file
-	err := writeRunner(config, migrationsDir)
+	err = writeRunner(config, migrationsDir)
 	if err != nil {
 		return err
 	}
@@ -38,7 +54,8 @@ func Init() error {
 		return err
 	}
 
-	return nil
+	//---create the manifest that the CLI reads its config from
+	return writeManifest(config)
 }
 
 // NewMigration creates a new migration
@@ -58,12 +75,45 @@ func NewMigration(config shared.MigratorConfig, description string) (shared.Migr
 
 	err := csgen.WriteGeneratedGoFile(migrationFilePath, builder.String())
 	if err != nil {
-		log.Fatal(err)
+		return migration, err
+	}
+
+	return migration, writeCatalogFile(config)
+}
+
+// NewSQLMigration creates the up and down files for a plain SQL migration.  SurrealDB projects get SurrealQL
+// files, all other strategies get SQL files.
+func NewSQLMigration(config shared.MigratorConfig, description string) (shared.Migration, error) {
+	ext := ".sql"
+	if config.DatabaseStrategyName == "surrealdb" {
+		ext = ".surql"
+	}
+
+	migration := shared.Migration{
+		Package:     config.GeneratorPackage,
+		Name:        getMigrationName(),
+		Description: description,
 	}
 
-	writeCatalogFile(config)
+	for _, direction := range []shared.Direction{shared.DirectionUp, shared.DirectionDown} {
+		contents := csgen.ExecuteTemplate("sqlMigration", sqlMigrationTemplateString, map[string]interface{}{
+			"Migration": migration,
+			"Direction": direction,
+		})
 
-	return migration, nil
+		file := path.Join(config.GeneratorPath, fmt.Sprintf("%s.%s%s", migration.Name, direction, ext))
+		err := os.WriteFile(file, []byte(contents), 0644)
+		if err != nil {
+			return migration, err
+		}
+
+		if direction == shared.DirectionUp {
+			migration.FilePath = file
+		}
+	}
+
+	//---the new files are embedded by the catalog
+	return migration, writeCatalogFile(config)
 }
 
 func RemoveMigration(config shared.MigratorConfig, name string) error {
@@ -72,8 +122,9 @@ func RemoveMigration(config shared.MigratorConfig, name string) error {
 	if err != nil {
 		return err
 	}
+	defer strategy.Close()
 
-	am, err := strategy.FindAppliedMigrations(strategy.DBConfig)
+	am, err := strategy.FindApplied(context.Background())
 	if err != nil {
 		return err
 	}
@@ -81,26 +132,43 @@ func RemoveMigration(config shared.MigratorConfig, name string) error {
 	//---stop if the migration has already been applied as this will taint the version sequence
 	for _, m := range am {
 		if m.Name == name {
-			return fmt.Errorf("cannot remove migration %s, it has already been applied", name)
+			return fmt.Errorf("cannot remove migration %s: %w", name, shared.ErrAlreadyApplied)
 		}
 	}
 
-	//---remove the migration file
-	migrationFilePath := path.Join(config.GeneratorPath, fmt.Sprintf("%s_gen.go", name))
-	err = os.Remove(migrationFilePath)
-	if err != nil {
-		return err
+	//---remove the migration file, or the up and down files of a SQL migration
+	migrationFileNames := []string{fmt.Sprintf("%s_gen.go", name)}
+	for _, ext := range migrate.SQLMigrationExtensions {
+		migrationFileNames = append(migrationFileNames, name+".up"+ext, name+".down"+ext)
 	}
 
-	writeCatalogFile(config)
+	removed := false
+	for _, fileName := range migrationFileNames {
+		err = os.Remove(path.Join(config.GeneratorPath, fileName))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
 
-	return nil
+		removed = true
+	}
+
+	if !removed {
+		return fmt.Errorf("migration %s was not found in %s", name, config.GeneratorPath)
+	}
+
+	return writeCatalogFile(config)
 }
 
 // ---remove the latest migration as long as it's not applied
 func RemoveLatestMigration(config shared.MigratorConfig) error {
 	//---remove the migration file
-	dms := migrate.FindDiscoveredMigrationFiles(config)
+	dms, err := migrate.FindDiscoveredMigrationFiles(config)
+	if err != nil {
+		return err
+	}
 
 	if len(dms) == 0 {
 		//---not an error, but nothing to do
@@ -111,14 +179,49 @@ func RemoveLatestMigration(config shared.MigratorConfig) error {
 }
 
 func writeCatalogFile(config shared.MigratorConfig) error {
-	migrations := migrate.FindDiscoveredMigrationFiles(config)
-	contents := csgen.ExecuteTemplate[[]shared.Migration]("catalog", catalogTemplateString, migrations)
+	discovered, err := migrate.FindDiscoveredMigrationFiles(config)
+	if err != nil {
+		return err
+	}
+
+	//---SQL migrations are read by the runner at run time, only Go migrations are compiled into the catalog
+	migrations := slices.DeleteFunc(discovered, func(m shared.Migration) bool {
+		return !strings.HasSuffix(m.FilePath, "_gen.go")
+	})
+
+	//---migration files are embedded so that the compiled package doesn't depend on the source tree.  SQL files
+	//---are run from the embedded copy and Go files are hashed to checksum the source the package was built from
+	goFiles := []string{}
+	for _, m := range migrations {
+		goFiles = append(goFiles, filepath.Base(m.FilePath))
+	}
+
+	sqlFiles := []string{}
+	for _, ext := range migrate.SQLMigrationExtensions {
+		for _, pattern := range []string{"*.up" + ext, "*.down" + ext} {
+			files, err := filepath.Glob(path.Join(config.GeneratorPath, pattern))
+			if err != nil {
+				return err
+			}
+
+			for _, file := range files {
+				sqlFiles = append(sqlFiles, filepath.Base(file))
+			}
+		}
+	}
+	slices.Sort(sqlFiles)
+
+	contents := csgen.ExecuteTemplate("catalog", catalogTemplateString, catalogData{
+		Migrations: migrations,
+		SQLFiles:   sqlFiles,
+		Files:      slices.Sorted(slices.Values(append(goFiles, sqlFiles...))),
+	})
 
 	builder := csgen.NewCSGenBuilderForFile("csmig", config.GeneratorPackage)
 	builder.WriteString(contents)
 
 	catalogPath := path.Join(config.GeneratorPath, "catalog.gen.go")
-	err := csgen.WriteGeneratedGoFile(catalogPath, builder.String())
+	err = csgen.WriteGeneratedGoFile(catalogPath, builder.String())
 	if err != nil {
 		return err
 	}
@@ -126,6 +229,12 @@ func writeCatalogFile(config shared.MigratorConfig) error {
 	return nil
 }
 
+func writeManifest(config shared.MigratorConfig) error {
+	contents := csgen.ExecuteTemplate("manifest", manifestTemplateString, config)
+
+	return os.WriteFile(config.ManifestPath, []byte(contents), 0644)
+}
+
 func writeRunner(config shared.MigratorConfig, outputPath string) error {
 	builder := csgen.NewCSGenBuilderForFile("csmig", config.GeneratorPackage)
 	builder.WriteString(runFileTemplateString)
@@ -148,37 +257,123 @@ func getMigrationFileContents(migration shared.Migration) string {
 	return contents
 }
 
+// catalogData the values rendered into the catalog template.
+type catalogData struct {
+	Migrations []shared.Migration
+	SQLFiles   []string
+
+	//---every migration file embedded in the catalog, Go and SQL
+	Files []string
+}
+
 var catalogTemplateString = `
 import (
+{{- if .Files }}
+	"embed"
+
+	"github.com/cscoding21/csmig/migrate"
+{{- end }}
 	"github.com/cscoding21/csmig/shared"
 )
 
 func FindDiscoveredMigrations() []shared.Migration {
 	out := []shared.Migration{}
 
-	//---Generated migrations will be appended here via code generation{{range .}}    
-	out = append(out, {{ .Name }}){{end}}
+	//---Generated migrations will be appended here via code generation{{range .Migrations}}    
+	out = append(out, migrate.WithChecksumFromFS(migrationFiles, {{ .Name }})){{end}}
 
 	return out
 }
+{{ if .Files }}
+//go:embed{{ range .Files }} {{ printf "%q" . }}{{ end }}
+var migrationFiles embed.FS
+{{ end }}
+{{- if .SQLFiles }}
+// FindEmbeddedSQLMigrations return the plain SQL migrations embedded in this package
+func FindEmbeddedSQLMigrations() ([]shared.Migration, error) {
+	return migrate.FromFS(migrationFiles, ".")
+}
+{{ else }}
+// FindEmbeddedSQLMigrations return the plain SQL migrations embedded in this package
+func FindEmbeddedSQLMigrations() ([]shared.Migration, error) {
+	return []shared.Migration{}, nil
+}
+{{ end }}`
+
+var manifestTemplateString = `# csmig configuration
+#
+# The csmig CLI reads this file from the working directory.  Any value can be overridden with a
+# CSMIG_* environment variable, e.g. CSMIG_DATABASE_STRATEGY_PASSWORD for database_strategy.password.
+
+# directory and Go package where migrations are generated
+generator_path: {{ printf "%q" .GeneratorPath }}
+generator_package: {{ printf "%q" .GeneratorPackage }}
+
+# persistence strategy used to record applied migrations: surrealdb, postgres, mysql, sqlite or memory
+database_strategy_name: {{ printf "%q" .DatabaseStrategyName }}
+
+# connection details for the database being migrated
+database_strategy:
+  name: {{ printf "%q" .DBConfig.Name }}
+  host: {{ printf "%q" .DBConfig.Host }}
+  port: {{ .DBConfig.Port }}
+  user: {{ printf "%q" .DBConfig.User }}
+  # prefer setting the password with CSMIG_DATABASE_STRATEGY_PASSWORD
+  password: {{ printf "%q" .DBConfig.Password }}
+  # the database name, or the database file path for sqlite
+  database: {{ printf "%q" .DBConfig.Database }}
+  # the SurrealDB namespace or the PostgreSQL schema that holds the version table
+  namespace: {{ printf "%q" .DBConfig.Namespace }}
+  # driver specific connection options, e.g. sslmode for postgres
+  # options:
+  #   sslmode: require
+
+# runs hold a lock so concurrent deploys can't apply the same migration twice
+lock:
+  # how long to wait for another run to finish
+  timeout: {{ printf "%q" .Lock.Timeout.String }}
+  # age at which a lock record left by a crashed run is taken over; postgres and mysql release
+  # their advisory locks when the session ends
+  stale_after: {{ printf "%q" .Lock.StaleAfter.String }}
+
+# how long a whole run and each migration within it may take, "0s" means no limit
+timeout: {{ printf "%q" .Timeout.String }}
+migration_timeout: {{ printf "%q" .MigrationTimeout.String }}
+
+# shell commands run around "up", "down" and "redo", in order.  A failing command stops the run, and
+# on_error commands run when a migration or another hook fails.  Each command receives CSMIG_HOOK_EVENT,
+# CSMIG_HOOK_MIGRATION, CSMIG_HOOK_DESCRIPTION, CSMIG_HOOK_DIRECTION, CSMIG_HOOK_STRATEGY and, for
+# on_error, CSMIG_HOOK_ERROR
+hooks:
+  before_all:{{ range .Hooks.BeforeAll }}
+    - {{ printf "%q" . }}{{ else }} []{{ end }}
+  after_all:{{ range .Hooks.AfterAll }}
+    - {{ printf "%q" . }}{{ else }} []{{ end }}
+  before_each:{{ range .Hooks.BeforeEach }}
+    - {{ printf "%q" . }}{{ else }} []{{ end }}
+  after_each:{{ range .Hooks.AfterEach }}
+    - {{ printf "%q" . }}{{ else }} []{{ end }}
+  on_error:{{ range .Hooks.OnError }}
+    - {{ printf "%q" . }}{{ else }} []{{ end }}
 `
 
 var migrationTemplateString = `
 import (
+	"context"
 	"fmt"
 	"github.com/cscoding21/csmig/shared"
 )
 
 var {{ .Name }} = shared.Migration{
 	Name:        "{{.Name}}",
-	Description: "{{.Description}}",
-	Up: func(ds shared.DatabaseStrategy) error {
+	Description: {{ printf "%q" .Description }},
+	UpContext: func(ctx context.Context, ds shared.DatabaseStrategy) error {
 		//---your code here
 		fmt.Printf("migration up for {{ .Name }} not implemented")
 
 		return nil
 	},
-	Down: func(ds shared.DatabaseStrategy) error {
+	DownContext: func(ctx context.Context, ds shared.DatabaseStrategy) error {
 		// your code here
 		fmt.Printf("migration down for {{ .Name }} not implemented")
 
@@ -187,144 +382,162 @@ var {{ .Name }} = shared.Migration{
 }
 `
 
+var sqlMigrationTemplateString = `-- {{ .Migration.Description }}
+{{- if eq .Direction "up" }}
+-- add "` + migrate.NoTransactionDirective + `" on its own line to run this migration outside a transaction
+{{- end }}
+
+`
+
 var runFileTemplateString = `
 import (
+	"context"
+	"slices"
+	"strings"
+
 	"github.com/cscoding21/csmig/migrate"
-	"github.com/cscoding21/csmig/persistence"
 	"github.com/cscoding21/csmig/shared"
 )
 
-// Apply run any migrations that have not been applied yet.
-func Apply(config shared.MigratorConfig) error {
-	discoveredMigrations := FindDiscoveredMigrations()
-
-	//---get the persistence strategy as defined in the config
-	strategy, err := persistence.GetPersistenceStrategy(config)
+// NewMigrator return a migrate.Migrator for the migrations in this package.  The functions below are shortcuts
+// that build a Migrator and run a single operation on it with a background context, use the Migrator directly
+// to cancel a run.
+func NewMigrator(config shared.MigratorConfig) (*migrate.Migrator, error) {
+	migrations, err := FindMigrations()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	//---make sure the required support tables have been created
-	err = strategy.EnsureInfrastructure(strategy.DBConfig)
-	if err != nil {
-		return err
-	}
+	return migrate.NewMigrator(config, migrations), nil
+}
+
+// Apply run any migrations that have not been applied yet.
+func Apply(config shared.MigratorConfig) error {
+	return ApplyTo(config, "")
+}
 
-	//---retrieve a list of migrations that have already been applied
-	appliedMigrations, err := strategy.FindAppliedMigrations(strategy.DBConfig)
+// ApplyTo run the migrations that have not been applied yet, in order, up to and including the named
+// migration.  An empty name applies every pending migration.
+func ApplyTo(config shared.MigratorConfig, name string) error {
+	migrator, err := NewMigrator(config)
 	if err != nil {
 		return err
 	}
 
-	//---iterate over the migrations that have been created and apply any that have not been applied yet
-	for _, dm := range discoveredMigrations {
-		//---skip if migration is already applied
-		if migrationIsApplied(dm.Name, appliedMigrations) {
-			continue
-		}
-
-		err = dm.Up(strategy)
-		if err != nil {
-			return err
-		}
-
-		err = strategy.ApplyMigration(strategy.DBConfig, dm.Name, dm.Description)
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return migrator.ApplyTo(context.Background(), name)
 }
 
 // Rollback call the "Down" method of the most recently applied migration
 func Rollback(config shared.MigratorConfig) error {
-	strategy, err := persistence.GetPersistenceStrategy(config)
+	return RollbackSteps(config, 1)
+}
+
+// RollbackSteps call the "Down" method of the given number of most recently applied migrations, newest first
+func RollbackSteps(config shared.MigratorConfig, steps int) error {
+	migrator, err := NewMigrator(config)
 	if err != nil {
 		return err
 	}
 
-	appliedMigrations, err := strategy.FindAppliedMigrations(strategy.DBConfig)
+	return migrator.RollbackSteps(context.Background(), steps)
+}
+
+// RollbackTo call the "Down" method of every migration newer than the named migration, newest first.
+// The named migration remains applied.
+func RollbackTo(config shared.MigratorConfig, name string) error {
+	migrator, err := NewMigrator(config)
 	if err != nil {
 		return err
 	}
 
-	latestMigration := getLatestMigration(appliedMigrations)
+	return migrator.RollbackTo(context.Background(), name)
+}
 
-	if latestMigration == nil {
-		return nil
+// Redo call the "Down" method of the most recently applied migration and then apply it again
+func Redo(config shared.MigratorConfig) error {
+	migrator, err := NewMigrator(config)
+	if err != nil {
+		return err
 	}
 
-	for _, dm := range FindDiscoveredMigrations() {
-		if latestMigration.Name == dm.Name {
-			err = dm.Down(strategy)
-			if err != nil {
-				return err
-			}
-		}
+	return migrator.Redo(context.Background())
+}
+
+// Reset remove the version records of every applied migration without calling their "Down" methods
+func Reset(config shared.MigratorConfig) error {
+	migrator, err := NewMigrator(config)
+	if err != nil {
+		return err
 	}
 
-	return strategy.RollbackMigration(strategy.DBConfig, latestMigration.Name)
+	return migrator.Reset(context.Background())
 }
 
-// FindAppliedMigrations return a list of all migrations that have been applied
-func FindAppliedMigrations(config shared.MigratorConfig) ([]shared.AppliedMigration, error) {
-	strategy, err := persistence.GetPersistenceStrategy(config)
+// PlanApply return the migrations ApplyTo would run, in order, without changing the database
+func PlanApply(config shared.MigratorConfig, name string) ([]shared.PlanStep, error) {
+	migrator, err := NewMigrator(config)
 	if err != nil {
 		return nil, err
 	}
 
-	return strategy.FindAppliedMigrations(strategy.DBConfig)
+	return migrator.PlanApply(context.Background(), name)
 }
 
-// FindUnappliedMigrations return a list of migrations that have not been applied yet.
-func FindUnappliedMigrations(config shared.MigratorConfig) ([]shared.Migration, error) {
-	strategy, err := persistence.GetPersistenceStrategy(config)
+// PlanRollback return the migrations RollbackTo (when name is set) or RollbackSteps would roll back, newest first,
+// without changing the database
+func PlanRollback(config shared.MigratorConfig, name string, steps int) ([]shared.PlanStep, error) {
+	migrator, err := NewMigrator(config)
 	if err != nil {
 		return nil, err
 	}
 
-	discoveredMigrations := FindDiscoveredMigrations()
-	appliedMigrations, err := migrate.FindAppliedMigrations(strategy)
+	return migrator.PlanRollback(context.Background(), name, steps)
+}
+
+// FindMigrations return the Go migrations in the catalog together with the plain SQL migrations embedded
+// from the migrations directory, ordered by name
+func FindMigrations() ([]shared.Migration, error) {
+	sqlMigrations, err := FindEmbeddedSQLMigrations()
 	if err != nil {
 		return nil, err
 	}
 
-	out := []shared.Migration{}
-
-	for _, dm := range discoveredMigrations {
-		if !migrationIsApplied(dm.Name, appliedMigrations) {
-			out = append(out, dm)
-		}
-	}
+	out := append(FindDiscoveredMigrations(), sqlMigrations...)
+	slices.SortFunc(out, func(a, b shared.Migration) int {
+		return strings.Compare(a.Name, b.Name)
+	})
 
 	return out, nil
 }
 
-func migrationIsApplied(name string, appliedMigrations []shared.AppliedMigration) bool {
-	for _, appliedMigration := range appliedMigrations {
-		if appliedMigration.Name == name {
-			return true
-		}
+// FindAppliedMigrations return a list of all migrations that have been applied
+func FindAppliedMigrations(config shared.MigratorConfig) ([]shared.AppliedMigration, error) {
+	migrator, err := NewMigrator(config)
+	if err != nil {
+		return nil, err
 	}
 
-	return false
+	return migrator.FindApplied(context.Background())
 }
 
-func getLatestMigration(appliedMigrations []shared.AppliedMigration) *shared.AppliedMigration {
-	if len(appliedMigrations) == 0 {
-		return nil
+// Verify return the applied migrations whose source has changed since they were applied
+func Verify(config shared.MigratorConfig) ([]shared.ChecksumMismatch, error) {
+	migrator, err := NewMigrator(config)
+	if err != nil {
+		return nil, err
 	}
 
-	out := appliedMigrations[len(appliedMigrations)-1]
+	return migrator.Verify(context.Background())
+}
 
-	for _, am := range appliedMigrations {
-		if am.Name > out.Name {
-			out = am
-		}
+// FindUnappliedMigrations return a list of migrations that have not been applied yet.
+func FindUnappliedMigrations(config shared.MigratorConfig) ([]shared.Migration, error) {
+	migrator, err := NewMigrator(config)
+	if err != nil {
+		return nil, err
 	}
 
-	return &out
+	return migrator.FindUnapplied(context.Background())
 }
 `
 
@@ -1,13 +1,30 @@
 package generate
 
 import (
+	"context"
+	"encoding/json"
+	"os"
+	"path"
+	"runtime"
+	"slices"
+	"strings"
 	"testing"
 
+	"github.com/cscoding21/csmig/migrate"
 	"github.com/cscoding21/csmig/shared"
+	"gopkg.in/yaml.v3"
 )
 
-func TestWriteCatalogFile(t *testing.T) {
+// getTestConfig returns the shared test config with migrations generated into a temporary directory.
+func getTestConfig(t *testing.T) shared.MigratorConfig {
 	config := shared.GetTestConfig()
+	config.GeneratorPath = t.TempDir()
+
+	return config
+}
+
+func TestWriteCatalogFile(t *testing.T) {
+	config := getTestConfig(t)
 	err := writeCatalogFile(config)
 	if err != nil {
 		t.Error(err)
@@ -29,7 +46,7 @@ func TestWriteMigrationFile(t *testing.T) {
 }
 
 func TestNewMigration(t *testing.T) {
-	config := shared.GetTestConfig()
+	config := getTestConfig(t)
 	mig, err := NewMigration(config, "This is a test migration")
 	if err != nil {
 		t.Error("TestNewMigration failed: ", err)
@@ -43,8 +60,88 @@ func TestNewMigration(t *testing.T) {
 	// }
 }
 
+func TestMigrationChecksum(t *testing.T) {
+	config := getTestConfig(t)
+	config.DatabaseStrategyName = "memory"
+	config.DBConfig = shared.DatabaseConfig{Database: t.Name()}
+
+	mig, err := NewMigration(config, "widgets table")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := path.Join(config.GeneratorPath, mig.Name+"_gen.go")
+	edit := func(from string, to string) {
+		contents, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = os.WriteFile(file, []byte(strings.Replace(string(contents), from, to, 1)), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	catalog, _ := os.ReadFile(path.Join(config.GeneratorPath, "catalog.gen.go"))
+	if !strings.Contains(string(catalog), `//go:embed "`+mig.Name+`_gen.go"`) {
+		t.Errorf("the catalog should embed the Go migration file:\n%s", catalog)
+	}
+
+	//---the compiled catalog hashes the embedded source, which is the file as it was when the package was built
+	compiled := func() *migrate.Migrator {
+		migration := migrate.WithChecksumFromFS(os.DirFS(config.GeneratorPath), shared.Migration{
+			Name: mig.Name,
+			Up:   func(ds shared.DatabaseStrategy) error { return nil },
+		})
+
+		return migrate.NewMigrator(config, []shared.Migration{migration})
+	}
+
+	verify := func() ([]shared.ChecksumMismatch, []shared.ChecksumMismatch) {
+		discovered, err := migrate.FindDiscoveredMigrationFiles(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		migrator := compiled()
+		applied, err := migrator.FindApplied(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		runtime, err := migrator.Verify(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return migrate.FindChecksumMismatches(discovered, applied), runtime
+	}
+
+	//---a migration edited after it was generated records the edited source when it is applied
+	edit("//---your code here", "//---create the widgets table")
+
+	err = compiled().Apply(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	onDisk, runtime := verify()
+	if len(onDisk) != 0 || len(runtime) != 0 {
+		t.Errorf("expected no drift for the migration as applied, got %v and %v", onDisk, runtime)
+	}
+
+	//---edits made after it was applied are reported by both
+	edit("//---create the widgets table", "//---create the widgets table, edited")
+
+	onDisk, runtime = verify()
+	if len(onDisk) != 1 || len(runtime) != 1 || onDisk[0].Name != mig.Name || runtime[0].Current != onDisk[0].Current {
+		t.Errorf("expected the edit to be reported as drift, got %v and %v", onDisk, runtime)
+	}
+}
+
 func TestRemoveMigration(t *testing.T) {
-	config := shared.GetTestConfig()
+	config := getTestConfig(t)
 
 	//---ensure there is a migration to remove
 	mig, err := NewMigration(config, "This is a test migration for integration testing")
@@ -58,8 +155,45 @@ func TestRemoveMigration(t *testing.T) {
 	}
 }
 
+func TestNewSQLMigration(t *testing.T) {
+	config := getTestConfig(t)
+
+	mig, err := NewSQLMigration(config, "widgets table")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	discovered, err := migrate.FindDiscoveredMigrationFiles(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(discovered) != 1 || discovered[0].Name != mig.Name || discovered[0].Description != "widgets table" {
+		t.Errorf("unexpected discovered migrations %v", discovered)
+	}
+
+	catalog, _ := os.ReadFile(path.Join(config.GeneratorPath, "catalog.gen.go"))
+	if !strings.Contains(string(catalog), "//go:embed") || !strings.Contains(string(catalog), mig.Name+".up.sql") {
+		t.Error("the catalog should embed the SQL migration files")
+	}
+
+	err = RemoveMigration(config, mig.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if discovered, _ := migrate.FindDiscoveredMigrationFiles(config); len(discovered) != 0 {
+		t.Error("both files of the SQL migration should have been removed")
+	}
+
+	catalog, _ = os.ReadFile(path.Join(config.GeneratorPath, "catalog.gen.go"))
+	if strings.Contains(string(catalog), "//go:embed") {
+		t.Error("the catalog should not embed files once none are left")
+	}
+}
+
 func TestRemoveLatestMigration(t *testing.T) {
-	config := shared.GetTestConfig()
+	config := getTestConfig(t)
 
 	err := RemoveLatestMigration(config)
 	if err != nil {
@@ -68,8 +202,104 @@ func TestRemoveLatestMigration(t *testing.T) {
 }
 
 func TestInit(t *testing.T) {
-	err := Init()
+	dir := t.TempDir()
+
+	config, err := NewProjectConfig(path.Join(dir, "csmig.yaml"), path.Join(dir, "db-migrations"), "", "postgres")
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
+	}
+
+	if config.GeneratorPackage != "db_migrations" {
+		t.Errorf("expected package to be derived from the directory, got %s", config.GeneratorPackage)
+	}
+
+	err = Init(config, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range []string{"runner.gen.go", "runner_test.go", "catalog.gen.go"} {
+		if _, err := os.Stat(path.Join(config.GeneratorPath, file)); err != nil {
+			t.Errorf("expected %s to be generated: %v", file, err)
+		}
+	}
+
+	manifest, err := os.ReadFile(config.ManifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(manifest), `database_strategy_name: "postgres"`) || !strings.Contains(string(manifest), "port: 5432") {
+		t.Errorf("unexpected manifest contents:\n%s", manifest)
+	}
+
+	//---hook commands written to the manifest read back unchanged
+	config.Hooks.BeforeAll = []string{`pg_dump "$DB" > snapshot.sql`}
+	err = writeManifest(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, _ = os.ReadFile(config.ManifestPath)
+	read := shared.MigratorConfig{}
+	err = yaml.Unmarshal(manifest, &read)
+	if err != nil {
+		t.Fatalf("the manifest should be valid yaml: %v\n%s", err, manifest)
+	}
+
+	if !slices.Equal(read.Hooks.BeforeAll, config.Hooks.BeforeAll) || len(read.Hooks.OnError) != 0 {
+		t.Errorf("unexpected hooks %+v", read.Hooks)
+	}
+
+	err = Init(config, false)
+	if err == nil {
+		t.Error("init should refuse to overwrite an existing setup")
+	}
+
+	err = Init(config, true)
+	if err != nil {
+		t.Error("init should overwrite an existing setup when forced: ", err)
+	}
+}
+
+func TestNewProjectConfigUnknownStrategy(t *testing.T) {
+	_, err := NewProjectConfig("csmig.yaml", "migrations", "", "oracle")
+	if err == nil {
+		t.Error("expected an error for an unknown strategy")
+	}
+}
+
+func TestWriteRunConfig(t *testing.T) {
+	config := getTestConfig(t)
+	config.DBConfig.Password = "secret"
+
+	file, err := writeRunConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file)
+
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Errorf("the config file holds the password and should only be readable by its owner, got %v", info.Mode().Perm())
+	}
+
+	configJSON, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	written := shared.MigratorConfig{}
+	err = json.Unmarshal(configJSON, &written)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if written.DBConfig.Password != "secret" || written.GeneratorPath != config.GeneratorPath {
+		t.Errorf("unexpected config %+v", written)
 	}
 }
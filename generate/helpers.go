@@ -2,9 +2,13 @@ package generate
 
 import (
 	"fmt"
+	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/cscoding21/csmig/persistence"
 	"github.com/cscoding21/csmig/shared"
 )
 
@@ -20,3 +24,71 @@ func NewMigrationObject(description string) shared.Migration {
 		Description: description,
 	}
 }
+
+// NewProjectConfig returns the config for a new project with the migrations in dir and
+// connection defaults suited to the named persistence strategy.
+func NewProjectConfig(manifestPath string, dir string, pkg string, strategyName string) (shared.MigratorConfig, error) {
+	dbConfig, ok := defaultDatabaseConfigs[strategyName]
+	if !ok {
+		if !slices.Contains(persistence.Strategies(), strategyName) {
+			return shared.MigratorConfig{}, fmt.Errorf("unknown persistence strategy %q", strategyName)
+		}
+
+		//---strategies registered by other packages get a blank connection section to fill in
+		dbConfig = shared.DatabaseConfig{Name: strategyName, Host: "localhost"}
+	}
+
+	if pkg == "" {
+		pkg = strings.ReplaceAll(filepath.Base(dir), "-", "_")
+	}
+
+	config := shared.MigratorConfig{
+		ManifestPath:         manifestPath,
+		GeneratorPath:        dir,
+		GeneratorPackage:     pkg,
+		ImplementationName:   "csmig",
+		DatabaseStrategyName: strategyName,
+		DBConfig:             dbConfig,
+		Lock: shared.LockConfig{
+			Timeout:    persistence.DefaultLockTimeout,
+			StaleAfter: persistence.DefaultLockStaleAfter,
+		},
+	}
+
+	return config, nil
+}
+
+var defaultDatabaseConfigs = map[string]shared.DatabaseConfig{
+	"surrealdb": {
+		Name:      "surrealdb",
+		Host:      "localhost",
+		Port:      8000,
+		User:      "root",
+		Password:  "root",
+		Database:  "test",
+		Namespace: "test",
+	},
+	"postgres": {
+		Name:      "postgres",
+		Host:      "localhost",
+		Port:      5432,
+		User:      "postgres",
+		Database:  "postgres",
+		Namespace: "public",
+	},
+	"mysql": {
+		Name:     "mysql",
+		Host:     "localhost",
+		Port:     3306,
+		User:     "root",
+		Database: "test",
+	},
+	"sqlite": {
+		Name:     "sqlite",
+		Database: "csmig.db",
+	},
+	"memory": {
+		Name:     "memory",
+		Database: "csmig",
+	},
+}
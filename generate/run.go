@@ -0,0 +1,310 @@
+package generate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cscoding21/csgen"
+	"github.com/cscoding21/csmig/shared"
+)
+
+// RunConfigEnvVar the environment variable naming the file that hands the resolved config to the generated
+// entrypoint.  The config holds the database password, so it is kept out of the environment itself.
+const RunConfigEnvVar = shared.RunConfigEnvVar
+
+// runWaitDelay how long an interrupted run has to stop before it is killed.
+var runWaitDelay = 30 * time.Second
+
+// RunMigrations compiles the project's migrations package together with a generated entrypoint and
+// runs the given runner command ("up", "down" or "redo") against the configured database.  The runner
+// and catalog are regenerated first so that they match the migrations on disk.  The Go toolchain must
+// be available and the working directory must be inside the module that contains the migrations.
+// Cancelling ctx interrupts the run, which stops after rolling back the migration in progress.
+func RunMigrations(ctx context.Context, config shared.MigratorConfig, command string, args ...string) error {
+	return runEntrypoint(ctx, config, command, args...)
+}
+
+// PlanMigrations returns the migrations that "up" (or "down" when down is true) would run with the given
+// target, without changing the database.  Like RunMigrations, it compiles the project's migrations package.
+func PlanMigrations(ctx context.Context, config shared.MigratorConfig, down bool, to string, steps int) ([]shared.PlanStep, error) {
+	out, err := os.CreateTemp("", "csmig-plan-*.json")
+	if err != nil {
+		return nil, err
+	}
+	out.Close()
+	defer os.Remove(out.Name())
+
+	err = runEntrypoint(ctx, config, "plan",
+		"--down="+strconv.FormatBool(down), "--to", to, "--steps", strconv.Itoa(steps), "--out", out.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	planJSON, err := os.ReadFile(out.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	plan := []shared.PlanStep{}
+	err = json.Unmarshal(planJSON, &plan)
+
+	return plan, err
+}
+
+func runEntrypoint(ctx context.Context, config shared.MigratorConfig, command string, args ...string) error {
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		return errors.New("the go toolchain is required to run migrations from the CLI")
+	}
+
+	err = writeRunner(config, config.GeneratorPath)
+	if err != nil {
+		return err
+	}
+
+	err = writeCatalogFile(config)
+	if err != nil {
+		return err
+	}
+
+	importPath, err := getPackageImportPath(goBin, config.GeneratorPath)
+	if err != nil {
+		return err
+	}
+
+	//---directories starting with an underscore are skipped by ./... so the entrypoint never leaks into builds
+	entryDir, err := os.MkdirTemp(config.GeneratorPath, "_csmig_run")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(entryDir)
+
+	err = writeEntrypoint(importPath, entryDir)
+	if err != nil {
+		return err
+	}
+
+	configFile, err := writeRunConfig(config)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(configFile)
+
+	//---the entrypoint is built rather than started with "go run" so that it receives the interrupt directly
+	binary := path.Join(entryDir, "csmig-run")
+	if runtime.GOOS == "windows" {
+		binary += ".exe"
+	}
+
+	build := exec.CommandContext(ctx, goBin, "build", "-o", binary, packageDir(entryDir))
+	build.Stdout = os.Stdout
+	build.Stderr = os.Stderr
+
+	err = build.Run()
+	if err != nil {
+		return fmt.Errorf("unable to build the migrations package: %w", err)
+	}
+
+	cmd := exec.CommandContext(ctx, binary, append([]string{command}, args...)...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%s", RunConfigEnvVar, configFile))
+
+	//---give the run a chance to roll back the migration in progress before it is killed
+	cmd.Cancel = func() error {
+		if err := cmd.Process.Signal(os.Interrupt); err != nil {
+			return cmd.Process.Kill()
+		}
+
+		return nil
+	}
+	cmd.WaitDelay = runWaitDelay
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	err = cmd.Run()
+	if err != nil {
+		return runError(command, err)
+	}
+
+	return nil
+}
+
+// writeRunConfig writes the config to a temporary file that only the current user can read and returns its path.
+// The caller removes the file once the run is over.
+func writeRunConfig(config shared.MigratorConfig) (string, error) {
+	configJSON, err := json.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+
+	//---CreateTemp creates the file with mode 0600, which writing to it keeps
+	file, err := os.CreateTemp("", "csmig-config-*.json")
+	if err != nil {
+		return "", err
+	}
+	file.Close()
+
+	err = os.WriteFile(file.Name(), configJSON, 0600)
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+
+	return file.Name(), nil
+}
+
+// runError turns the exit code of a failed run back into the typed error that caused it.  The run has already
+// written the full error to stderr.
+func runError(command string, err error) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if typed := shared.ExitCodeError(exitErr.ExitCode()); typed != nil {
+			return fmt.Errorf("csmig %s failed: %w", command, typed)
+		}
+	}
+
+	return fmt.Errorf("migration %s failed: %w", command, err)
+}
+
+func getPackageImportPath(goBin string, dir string) (string, error) {
+	out, err := exec.Command(goBin, "list", "-f", "{{.ImportPath}}", packageDir(dir)).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return "", fmt.Errorf("unable to resolve the migrations package in %s: %s", dir, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+
+		return "", err
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+func writeEntrypoint(importPath string, outputPath string) error {
+	builder := csgen.NewCSGenBuilderForFile("csmig", "main")
+	builder.WriteString(csgen.ExecuteTemplate("entrypoint", entrypointTemplateString, importPath))
+
+	file := path.Join(outputPath, "main.go")
+	return csgen.WriteGeneratedGoFile(file, builder.String())
+}
+
+// packageDir makes a relative directory usable as a package path for the go command.
+func packageDir(dir string) string {
+	if filepath.IsAbs(dir) {
+		return dir
+	}
+
+	return "./" + filepath.ToSlash(filepath.Clean(dir))
+}
+
+var entrypointTemplateString = `
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/cscoding21/csmig/migrate"
+	"github.com/cscoding21/csmig/shared"
+	migrations "{{ . }}"
+)
+
+func main() {
+	config := shared.MigratorConfig{}
+
+	configJSON, err := os.ReadFile(os.Getenv("` + RunConfigEnvVar + `"))
+	if err != nil {
+		fail(err)
+	}
+
+	err = json.Unmarshal(configJSON, &config)
+	if err != nil {
+		fail(err)
+	}
+
+	if len(os.Args) < 2 {
+		fail(fmt.Errorf("no command given"))
+	}
+
+	flags := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	to := flags.String("to", "", "the target migration")
+	steps := flags.Int("steps", 1, "the number of migrations to roll back")
+	down := flags.Bool("down", false, "plan a rollback rather than an apply")
+	out := flags.String("out", "", "the file the plan is written to")
+	flags.Parse(os.Args[2:])
+
+	//---an interrupt cancels the migration in progress, which is rolled back before the run stops
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	migrator, err := migrations.NewMigrator(config)
+	if err != nil {
+		fail(err)
+	}
+
+	//---shell commands from the hooks section of the config run around the migrations
+	migrator.UseHookCommands(config.Hooks)
+
+	switch os.Args[1] {
+	case "up":
+		err = migrator.ApplyTo(ctx, *to)
+	case "down":
+		if *to != "" {
+			err = migrator.RollbackTo(ctx, *to)
+		} else {
+			err = migrator.RollbackSteps(ctx, *steps)
+		}
+	case "redo":
+		err = migrator.Redo(ctx)
+	case "plan":
+		err = writePlan(ctx, migrator, *down, *to, *steps, *out)
+	default:
+		err = fmt.Errorf("unknown command %s", os.Args[1])
+	}
+
+	if err != nil {
+		fail(err)
+	}
+}
+
+func writePlan(ctx context.Context, migrator *migrate.Migrator, down bool, to string, steps int, out string) error {
+	var plan []shared.PlanStep
+	var err error
+
+	if down {
+		plan, err = migrator.PlanRollback(ctx, to, steps)
+	} else {
+		plan, err = migrator.PlanApply(ctx, to)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	planJSON, err := json.Marshal(plan)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(out, planJSON, 0644)
+}
+
+//---the exit code tells csmig which typed error stopped the run
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(shared.ExitCode(err))
+}
+`
@@ -0,0 +1,171 @@
+package migrate
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/cscoding21/csmig/shared"
+)
+
+// sharedImportPath the import path of the package declaring shared.Migration.
+const sharedImportPath = "github.com/cscoding21/csmig/shared"
+
+// computedMigrationFields fields of shared.Migration that are set by discovery rather than read from the file.
+var computedMigrationFields = []string{"FilePath", "Package", "Checksum"}
+
+// ReadGoMigration parse a generated m*_gen.go migration file and return the values of its shared.Migration
+// literal.  String and bool fields such as Name, Description and NoTransaction are read from the source, so
+// file discovery reports the same values as the compiled catalog.  Fields set to anything other than a literal,
+// such as a constant, are left empty since only the compiler knows their value.  Name identifies the migration
+// and must be a literal matching the file name.  Up and Down are left nil.
+func ReadGoMigration(file string, contents []byte) (shared.Migration, error) {
+	migration := shared.Migration{FilePath: file}
+	varName := strings.TrimSuffix(filepath.Base(file), "_gen.go")
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, file, contents, parser.SkipObjectResolution)
+	if err != nil {
+		return migration, fmt.Errorf("unable to parse migration file: %w", err)
+	}
+
+	literal, err := findMigrationLiteral(f, varName)
+	if err != nil {
+		return migration, fmt.Errorf("%s: %w", file, err)
+	}
+
+	value := reflect.ValueOf(&migration).Elem()
+	for _, elt := range literal.Elts {
+		kv, ok := elt.(*ast.KeyValueExpr)
+		if !ok {
+			return migration, fmt.Errorf("%s: the shared.Migration literal must use field names", fset.Position(elt.Pos()))
+		}
+
+		key, ok := kv.Key.(*ast.Ident)
+		if !ok {
+			continue
+		}
+
+		field := value.FieldByName(key.Name)
+		if !field.IsValid() || slices.Contains(computedMigrationFields, key.Name) {
+			continue
+		}
+
+		switch field.Kind() {
+		case reflect.String:
+			s, err := stringLiteral(kv.Value)
+			if err != nil && key.Name == "Name" {
+				return migration, fmt.Errorf("%s: field %s %w", fset.Position(kv.Value.Pos()), key.Name, err)
+			}
+			field.SetString(s)
+		case reflect.Bool:
+			b, _ := boolLiteral(kv.Value)
+			field.SetBool(b)
+		}
+	}
+
+	//---the catalog refers to the migration by the variable named after the file, so the two have to agree
+	if migration.Name != varName {
+		return migration, fmt.Errorf("%s: migration Name %q does not match the file name %q", file, migration.Name, varName)
+	}
+
+	return migration, nil
+}
+
+// findMigrationLiteral return the composite literal assigned to the package level variable named varName.
+func findMigrationLiteral(f *ast.File, varName string) (*ast.CompositeLit, error) {
+	sharedName := ""
+	for _, imp := range f.Imports {
+		if path, _ := strconv.Unquote(imp.Path.Value); path == sharedImportPath {
+			sharedName = "shared"
+			if imp.Name != nil {
+				sharedName = imp.Name.Name
+			}
+		}
+	}
+
+	if sharedName == "" {
+		return nil, fmt.Errorf("migration files must import %s", sharedImportPath)
+	}
+
+	for _, decl := range f.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.VAR {
+			continue
+		}
+
+		for _, spec := range gen.Specs {
+			vs := spec.(*ast.ValueSpec)
+			for i, ident := range vs.Names {
+				if ident.Name != varName || i >= len(vs.Values) {
+					continue
+				}
+
+				literal, ok := vs.Values[i].(*ast.CompositeLit)
+				if !ok || !isSelector(literal.Type, sharedName, "Migration") {
+					return nil, fmt.Errorf("variable %s must be a %s.Migration literal", varName, sharedName)
+				}
+
+				return literal, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("no variable %s = %s.Migration{...} was found", varName, sharedName)
+}
+
+func isSelector(expr ast.Expr, pkg string, name string) bool {
+	sel, ok := expr.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != name {
+		return false
+	}
+
+	x, ok := sel.X.(*ast.Ident)
+
+	return ok && x.Name == pkg
+}
+
+// stringLiteral return the value of a string literal, or of string literals joined with +.
+func stringLiteral(expr ast.Expr) (string, error) {
+	switch e := expr.(type) {
+	case *ast.ParenExpr:
+		return stringLiteral(e.X)
+	case *ast.BinaryExpr:
+		if e.Op != token.ADD {
+			break
+		}
+
+		x, err := stringLiteral(e.X)
+		if err != nil {
+			return "", err
+		}
+
+		y, err := stringLiteral(e.Y)
+		if err != nil {
+			return "", err
+		}
+
+		return x + y, nil
+	case *ast.BasicLit:
+		if e.Kind == token.STRING {
+			return strconv.Unquote(e.Value)
+		}
+	}
+
+	return "", fmt.Errorf("must be a string literal")
+}
+
+func boolLiteral(expr ast.Expr) (bool, error) {
+	ident, ok := expr.(*ast.Ident)
+	if !ok || (ident.Name != "true" && ident.Name != "false") {
+		return false, fmt.Errorf("must be true or false")
+	}
+
+	return ident.Name == "true", nil
+}
@@ -0,0 +1,180 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/cscoding21/csmig/shared"
+)
+
+// migratorHooks the hooks registered on a Migrator, in registration order.
+type migratorHooks struct {
+	beforeAll  []shared.Hook
+	afterAll   []shared.Hook
+	beforeEach []shared.Hook
+	afterEach  []shared.Hook
+	onError    []shared.ErrorHook
+}
+
+// runStep a migration to run as part of an operation, in the given direction.
+type runStep struct {
+	migration shared.Migration
+	direction shared.Direction
+	run       func(ctx context.Context) error
+}
+
+// BeforeAll register a hook called before the first migration of a run.  Hooks are registered before the
+// migrator is used and are called in the order they were registered.  They aren't called when a run has
+// nothing to do.
+func (m *Migrator) BeforeAll(hook shared.Hook) {
+	m.hooks.beforeAll = append(m.hooks.beforeAll, hook)
+}
+
+// AfterAll register a hook called after the last migration of a run succeeds.
+func (m *Migrator) AfterAll(hook shared.Hook) {
+	m.hooks.afterAll = append(m.hooks.afterAll, hook)
+}
+
+// BeforeEach register a hook called before each migration runs.
+func (m *Migrator) BeforeEach(hook shared.Hook) {
+	m.hooks.beforeEach = append(m.hooks.beforeEach, hook)
+}
+
+// AfterEach register a hook called after each migration succeeds.
+func (m *Migrator) AfterEach(hook shared.Hook) {
+	m.hooks.afterEach = append(m.hooks.afterEach, hook)
+}
+
+// OnError register a hook called when a run fails, whether in a migration or in another hook.  It is called
+// even when the run was cancelled.
+func (m *Migrator) OnError(hook shared.ErrorHook) {
+	m.hooks.onError = append(m.hooks.onError, hook)
+}
+
+// UseHookCommands register the shell commands of the config's hooks section.  Each command receives the
+// CSMIG_HOOK_EVENT, CSMIG_HOOK_MIGRATION, CSMIG_HOOK_DESCRIPTION, CSMIG_HOOK_DIRECTION and CSMIG_HOOK_STRATEGY
+// environment variables, and CSMIG_HOOK_ERROR when the run failed.
+func (m *Migrator) UseHookCommands(commands shared.HookCommands) {
+	register := func(event shared.HookEvent, commands []string, add func(shared.Hook)) {
+		for _, command := range commands {
+			add(func(ctx context.Context, migration shared.Migration, direction shared.Direction, ds shared.DatabaseStrategy) error {
+				return runHookCommand(ctx, command, hookEnv(event, migration, direction, ds))
+			})
+		}
+	}
+
+	register(shared.HookBeforeAll, commands.BeforeAll, m.BeforeAll)
+	register(shared.HookAfterAll, commands.AfterAll, m.AfterAll)
+	register(shared.HookBeforeEach, commands.BeforeEach, m.BeforeEach)
+	register(shared.HookAfterEach, commands.AfterEach, m.AfterEach)
+
+	for _, command := range commands.OnError {
+		m.OnError(func(ctx context.Context, migration shared.Migration, direction shared.Direction, ds shared.DatabaseStrategy, err error) error {
+			env := append(hookEnv(shared.HookOnError, migration, direction, ds), "CSMIG_HOOK_ERROR="+err.Error())
+
+			return runHookCommand(ctx, command, env)
+		})
+	}
+}
+
+// runSteps runs the steps of an operation in order, calling the hooks around them.  A failure stops the
+// operation and is passed to the OnError hooks.
+func (m *Migrator) runSteps(ctx context.Context, strategy shared.DatabaseStrategy, steps []runStep) error {
+	if len(steps) == 0 {
+		return nil
+	}
+
+	first, last := steps[0], steps[len(steps)-1]
+
+	err := callHooks(ctx, shared.HookBeforeAll, m.hooks.beforeAll, first, strategy)
+	if err != nil {
+		return m.failed(ctx, first, strategy, err)
+	}
+
+	for _, step := range steps {
+		err = callHooks(ctx, shared.HookBeforeEach, m.hooks.beforeEach, step, strategy)
+		if err == nil {
+			err = step.run(ctx)
+		}
+		if err == nil {
+			err = callHooks(ctx, shared.HookAfterEach, m.hooks.afterEach, step, strategy)
+		}
+		if err != nil {
+			return m.failed(ctx, step, strategy, err)
+		}
+	}
+
+	err = callHooks(ctx, shared.HookAfterAll, m.hooks.afterAll, last, strategy)
+	if err != nil {
+		return m.failed(ctx, last, strategy, err)
+	}
+
+	return nil
+}
+
+// failed calls the OnError hooks and returns err together with any error they return.
+func (m *Migrator) failed(ctx context.Context, step runStep, strategy shared.DatabaseStrategy, err error) error {
+	//---a cancelled run still reports its failure
+	ctx = context.WithoutCancel(ctx)
+
+	errs := []error{err}
+	for _, hook := range m.hooks.onError {
+		if hookErr := hook(ctx, step.migration, step.direction, strategy, err); hookErr != nil {
+			errs = append(errs, fmt.Errorf("%s hook failed: %w", shared.HookOnError, hookErr))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func callHooks(ctx context.Context, event shared.HookEvent, hooks []shared.Hook, step runStep, strategy shared.DatabaseStrategy) error {
+	for _, hook := range hooks {
+		err := hook(ctx, step.migration, step.direction, strategy)
+		if err != nil {
+			return fmt.Errorf("%s hook failed: %w", event, err)
+		}
+	}
+
+	return nil
+}
+
+func hookEnv(event shared.HookEvent, migration shared.Migration, direction shared.Direction, ds shared.DatabaseStrategy) []string {
+	//---the run config holds the database password, which hook commands have no business reading
+	env := slices.DeleteFunc(os.Environ(), func(v string) bool {
+		return strings.HasPrefix(v, shared.RunConfigEnvVar+"=")
+	})
+
+	return append(env,
+		"CSMIG_HOOK_EVENT="+string(event),
+		"CSMIG_HOOK_MIGRATION="+migration.Name,
+		"CSMIG_HOOK_DESCRIPTION="+migration.Description,
+		"CSMIG_HOOK_DIRECTION="+string(direction),
+		"CSMIG_HOOK_STRATEGY="+ds.Name(),
+	)
+}
+
+// runHookCommand runs a hook command through the shell, sharing the run's output.
+func runHookCommand(ctx context.Context, command string, env []string) error {
+	shell, flag := "sh", "-c"
+	if runtime.GOOS == "windows" {
+		shell, flag = "cmd", "/C"
+	}
+
+	cmd := exec.CommandContext(ctx, shell, flag, command)
+	cmd.Env = env
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("%q: %w", command, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,556 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cscoding21/csmig/persistence"
+	"github.com/cscoding21/csmig/shared"
+)
+
+// Migrator applies and rolls back a set of migrations against the database described by its config.  The
+// generated runner builds one from the project's catalog, but it can be used directly by programs that
+// assemble their own list of migrations.  Every operation stops when its context is done, within the
+// config's Timeout for the whole operation and MigrationTimeout for each migration.  Hooks registered with
+// BeforeAll, AfterAll, BeforeEach, AfterEach and OnError are called around the migrations an operation runs.
+type Migrator struct {
+	config     shared.MigratorConfig
+	migrations []shared.Migration
+	hooks      migratorHooks
+}
+
+// NewMigrator return a Migrator for the given migrations.  Migrations run in name order regardless of the
+// order they are given in.
+func NewMigrator(config shared.MigratorConfig, migrations []shared.Migration) *Migrator {
+	sorted := slices.Clone(migrations)
+	slices.SortFunc(sorted, func(a, b shared.Migration) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return &Migrator{
+		config:     config,
+		migrations: sorted,
+	}
+}
+
+// Migrations return the migrations managed by the migrator, ordered by name
+func (m *Migrator) Migrations() []shared.Migration {
+	return slices.Clone(m.migrations)
+}
+
+// Apply run any migrations that have not been applied yet.
+func (m *Migrator) Apply(ctx context.Context) error {
+	return m.ApplyTo(ctx, "")
+}
+
+// ApplyTo run the migrations that have not been applied yet, in order, up to and including the named
+// migration.  An empty name applies every pending migration.
+func (m *Migrator) ApplyTo(ctx context.Context, name string) error {
+	return m.withLock(ctx, func(ctx context.Context, strategy shared.DatabaseStrategy) error {
+		//---make sure the required support tables have been created
+		err := strategy.EnsureInfrastructure(ctx)
+		if err != nil {
+			return err
+		}
+
+		appliedMigrations, err := m.findClean(ctx, strategy)
+		if err != nil {
+			return err
+		}
+
+		pendingMigrations, err := m.findPendingTo(appliedMigrations, name)
+		if err != nil {
+			return err
+		}
+
+		steps := []runStep{}
+		for _, dm := range pendingMigrations {
+			steps = append(steps, m.applyStep(strategy, dm))
+		}
+
+		return m.runSteps(ctx, strategy, steps)
+	})
+}
+
+// Rollback call the "Down" method of the most recently applied migration
+func (m *Migrator) Rollback(ctx context.Context) error {
+	return m.RollbackSteps(ctx, 1)
+}
+
+// RollbackSteps call the "Down" method of the given number of most recently applied migrations, newest first.
+// steps must be at least 1.
+func (m *Migrator) RollbackSteps(ctx context.Context, steps int) error {
+	return m.rollback(ctx, "", steps)
+}
+
+// RollbackTo call the "Down" method of every migration newer than the named migration, newest first.
+// The named migration remains applied.
+func (m *Migrator) RollbackTo(ctx context.Context, name string) error {
+	return m.rollback(ctx, name, 0)
+}
+
+// Redo call the "Down" method of the most recently applied migration and then apply it again
+func (m *Migrator) Redo(ctx context.Context) error {
+	return m.withLock(ctx, func(ctx context.Context, strategy shared.DatabaseStrategy) error {
+		//---the migration is recorded again, which needs the columns added to older version tables
+		err := strategy.EnsureInfrastructure(ctx)
+		if err != nil {
+			return err
+		}
+
+		appliedMigrations, err := m.findClean(ctx, strategy)
+		if err != nil {
+			return err
+		}
+
+		latestMigration := getLatestMigration(appliedMigrations)
+		if latestMigration == nil {
+			return nil
+		}
+
+		dm := findMigration(latestMigration.Name, m.migrations)
+		if dm == nil {
+			return fmt.Errorf("migration %s has been applied but was not found in the catalog", latestMigration.Name)
+		}
+
+		return m.runSteps(ctx, strategy, []runStep{
+			m.rollbackStep(strategy, *latestMigration),
+			m.applyStep(strategy, *dm),
+		})
+	})
+}
+
+// Reset remove the version records of every applied migration without calling their "Down" methods
+func (m *Migrator) Reset(ctx context.Context) error {
+	return m.withLock(ctx, func(ctx context.Context, strategy shared.DatabaseStrategy) error {
+		return strategy.Reset(ctx)
+	})
+}
+
+// PlanApply return the migrations ApplyTo would run, in order, without changing the database.  The statements
+// each migration passes to Exec are captured by running its "Up" method against a recording strategy.
+func (m *Migrator) PlanApply(ctx context.Context, name string) ([]shared.PlanStep, error) {
+	appliedMigrations, err := m.FindApplied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pendingMigrations, err := m.findPendingTo(appliedMigrations, name)
+	if err != nil {
+		return nil, err
+	}
+
+	out := []shared.PlanStep{}
+	for _, dm := range pendingMigrations {
+		out = append(out, planMigration(ctx, dm, shared.DirectionUp))
+	}
+
+	return out, nil
+}
+
+// PlanRollback return the migrations RollbackTo (when name is set) or RollbackSteps would roll back, newest first,
+// without changing the database.  The statements are captured by running each "Down" method against a recording strategy.
+func (m *Migrator) PlanRollback(ctx context.Context, name string, steps int) ([]shared.PlanStep, error) {
+	appliedMigrations, err := m.FindApplied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rollbackMigrations, err := findRollbackMigrations(appliedMigrations, name, steps)
+	if err != nil {
+		return nil, err
+	}
+
+	out := []shared.PlanStep{}
+	for _, am := range rollbackMigrations {
+		dm := findMigration(am.Name, m.migrations)
+		if dm == nil {
+			out = append(out, shared.PlanStep{
+				Name:        am.Name,
+				Description: am.Description,
+				Direction:   shared.DirectionDown,
+				Warning:     "migration was not found in the catalog, only its version record will be removed",
+			})
+
+			continue
+		}
+
+		out = append(out, planMigration(ctx, *dm, shared.DirectionDown))
+	}
+
+	return out, nil
+}
+
+// FindApplied return a list of all migrations that have been applied
+func (m *Migrator) FindApplied(ctx context.Context) ([]shared.AppliedMigration, error) {
+	var out []shared.AppliedMigration
+
+	err := m.withStrategy(ctx, func(ctx context.Context, strategy shared.DatabaseStrategy) error {
+		var err error
+		out, err = strategy.FindApplied(ctx)
+
+		return err
+	})
+
+	return out, err
+}
+
+// FindUnapplied return a list of migrations that have not been applied yet.
+func (m *Migrator) FindUnapplied(ctx context.Context) ([]shared.Migration, error) {
+	appliedMigrations, err := m.FindApplied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return FindPendingMigrations(m.migrations, appliedMigrations), nil
+}
+
+// Verify return the applied migrations whose source has changed since they were applied
+func (m *Migrator) Verify(ctx context.Context) ([]shared.ChecksumMismatch, error) {
+	appliedMigrations, err := m.FindApplied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return FindChecksumMismatches(m.migrations, appliedMigrations), nil
+}
+
+func (m *Migrator) rollback(ctx context.Context, name string, steps int) error {
+	return m.withLock(ctx, func(ctx context.Context, strategy shared.DatabaseStrategy) error {
+		appliedMigrations, err := m.findClean(ctx, strategy)
+		if err != nil {
+			return err
+		}
+
+		rollbackMigrations, err := findRollbackMigrations(appliedMigrations, name, steps)
+		if err != nil {
+			return err
+		}
+
+		steps := []runStep{}
+		for _, am := range rollbackMigrations {
+			steps = append(steps, m.rollbackStep(strategy, am))
+		}
+
+		return m.runSteps(ctx, strategy, steps)
+	})
+}
+
+// applyStep returns the step that applies a migration
+func (m *Migrator) applyStep(strategy shared.DatabaseStrategy, migration shared.Migration) runStep {
+	return runStep{
+		migration: migration,
+		direction: shared.DirectionUp,
+		run: func(ctx context.Context) error {
+			return m.applyMigration(ctx, strategy, migration)
+		},
+	}
+}
+
+// rollbackStep returns the step that rolls back an applied migration.  Hooks of a migration missing from the
+// catalog receive the name and description from its version record.
+func (m *Migrator) rollbackStep(strategy shared.DatabaseStrategy, appliedMigration shared.AppliedMigration) runStep {
+	migration := shared.Migration{Name: appliedMigration.Name, Description: appliedMigration.Description}
+	if dm := findMigration(appliedMigration.Name, m.migrations); dm != nil {
+		migration = *dm
+	}
+
+	return runStep{
+		migration: migration,
+		direction: shared.DirectionDown,
+		run: func(ctx context.Context) error {
+			return m.rollbackMigration(ctx, strategy, appliedMigration)
+		},
+	}
+}
+
+// withStrategy runs fn with a strategy for the configured database, closing it afterwards.  The config's
+// Timeout applies to everything fn does.
+func (m *Migrator) withStrategy(ctx context.Context, fn func(ctx context.Context, strategy shared.DatabaseStrategy) error) error {
+	ctx, cancel := withTimeout(ctx, m.config.Timeout)
+	defer cancel()
+
+	strategy, err := persistence.GetPersistenceStrategy(m.config)
+	if err != nil {
+		return err
+	}
+	defer strategy.Close()
+
+	return fn(ctx, strategy)
+}
+
+// withLock runs fn while holding the migration lock so that concurrent runs can't apply the same migrations
+func (m *Migrator) withLock(ctx context.Context, fn func(ctx context.Context, strategy shared.DatabaseStrategy) error) error {
+	return m.withStrategy(ctx, func(ctx context.Context, strategy shared.DatabaseStrategy) error {
+		err := strategy.Lock(ctx, m.config.Lock.Timeout, m.config.Lock.StaleAfter)
+		if err != nil {
+			return err
+		}
+		defer strategy.Unlock()
+
+		return fn(ctx, strategy)
+	})
+}
+
+// findClean returns the applied migrations, or an error when one of them is dirty
+func (m *Migrator) findClean(ctx context.Context, strategy shared.DatabaseStrategy) ([]shared.AppliedMigration, error) {
+	appliedMigrations, err := strategy.FindApplied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	//---a migration that failed part way through needs to be resolved by hand first
+	err = checkDirty(appliedMigrations)
+	if err != nil {
+		return nil, err
+	}
+
+	return appliedMigrations, nil
+}
+
+// findPendingTo returns the pending migrations in order, up to and including the named migration when a
+// name is given
+func (m *Migrator) findPendingTo(appliedMigrations []shared.AppliedMigration, name string) ([]shared.Migration, error) {
+	if name != "" && findMigration(name, m.migrations) == nil {
+		return nil, fmt.Errorf("migration %s was not found in the catalog", name)
+	}
+
+	out := []shared.Migration{}
+
+	for _, dm := range m.migrations {
+		if !migrationIsApplied(dm.Name, appliedMigrations) {
+			out = append(out, dm)
+		}
+
+		if dm.Name == name {
+			break
+		}
+	}
+
+	return out, nil
+}
+
+// applyMigration runs the migration's "Up" method.  A failed migration running in a transaction is rolled back
+// together with its version record.  One that opts out of the transaction, or runs against a database that
+// commits schema changes implicitly, writes a dirty version record first, completed once it succeeds, so a
+// failure leaves a record behind that stops later runs until it is resolved.
+func (m *Migrator) applyMigration(ctx context.Context, strategy shared.DatabaseStrategy, migration shared.Migration) error {
+	//---don't start another migration once the run has been cancelled
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx, m.config.MigrationTimeout)
+	defer cancel()
+
+	dirty := tracksDirty(strategy, migration)
+	if dirty {
+		err := strategy.Start(ctx, NewAppliedMigration(migration, 0))
+		if err != nil {
+			return err
+		}
+	}
+
+	err := inTransaction(ctx, strategy, migration.NoTransaction, func(ds shared.DatabaseStrategy) error {
+		start := time.Now()
+		err := migration.RunUp(ctx, ds)
+		if err != nil {
+			return err
+		}
+
+		return ds.Apply(ctx, NewAppliedMigration(migration, time.Since(start)))
+	})
+	if err != nil {
+		return &shared.MigrationError{Name: migration.Name, Direction: shared.DirectionUp, Dirty: dirty, Err: err}
+	}
+
+	return nil
+}
+
+// tracksDirty reports whether a migration can fail part way through and so is recorded as dirty while it runs
+func tracksDirty(strategy shared.DatabaseStrategy, migration shared.Migration) bool {
+	return migration.NoTransaction || !strategy.TransactionalDDL()
+}
+
+// checkDirty returns an error when a migration failed part way through and hasn't been resolved
+func checkDirty(appliedMigrations []shared.AppliedMigration) error {
+	for _, am := range appliedMigrations {
+		if am.Dirty {
+			return fmt.Errorf("%w: %s failed part way through.  Check the database, then run \"csmig force %s --clean\" "+
+				"if its changes are not in place or \"csmig force %s --applied\" if they are", shared.ErrDirty, am.Name, am.Name, am.Name)
+		}
+	}
+
+	return nil
+}
+
+// rollbackMigration calls the migration's "Down" method and removes its version record.  Migrations missing
+// from the catalog can't run their "Down" method, but their record is still removed.  A migration that can
+// fail part way through has its record marked dirty first, so a failure stops later runs until it is resolved.
+func (m *Migrator) rollbackMigration(ctx context.Context, strategy shared.DatabaseStrategy, appliedMigration shared.AppliedMigration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx, m.config.MigrationTimeout)
+	defer cancel()
+
+	dm := findMigration(appliedMigration.Name, m.migrations)
+	if dm == nil {
+		return strategy.Rollback(ctx, appliedMigration.Name)
+	}
+
+	dirty := tracksDirty(strategy, *dm)
+	if dirty {
+		err := markDirty(ctx, strategy, appliedMigration)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := inTransaction(ctx, strategy, dm.NoTransaction, func(ds shared.DatabaseStrategy) error {
+		err := dm.RunDown(ctx, ds)
+		if err != nil {
+			return err
+		}
+
+		return ds.Rollback(ctx, appliedMigration.Name)
+	})
+	if err != nil {
+		return &shared.MigrationError{Name: dm.Name, Direction: shared.DirectionDown, Dirty: dirty, Err: err}
+	}
+
+	return nil
+}
+
+// markDirty replaces an applied migration's version record with a dirty one
+func markDirty(ctx context.Context, strategy shared.DatabaseStrategy, appliedMigration shared.AppliedMigration) error {
+	return strategy.Transaction(ctx, func(ds shared.DatabaseStrategy) error {
+		err := ds.Rollback(ctx, appliedMigration.Name)
+		if err != nil {
+			return err
+		}
+
+		return ds.Start(ctx, appliedMigration)
+	})
+}
+
+// inTransaction runs a migration together with its version record in one transaction unless it opts out
+func inTransaction(ctx context.Context, strategy shared.DatabaseStrategy, noTransaction bool, fn func(ds shared.DatabaseStrategy) error) error {
+	if noTransaction {
+		return fn(strategy)
+	}
+
+	return strategy.Transaction(ctx, fn)
+}
+
+// withTimeout returns ctx limited to the timeout, or ctx unchanged when the timeout is zero
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+func planMigration(ctx context.Context, migration shared.Migration, direction shared.Direction) shared.PlanStep {
+	step := shared.PlanStep{
+		Name:        migration.Name,
+		Description: migration.Description,
+		Direction:   direction,
+	}
+
+	run := migration.RunUp
+	if direction == shared.DirectionDown {
+		run = migration.RunDown
+	}
+
+	capture := persistence.NewCaptureStrategy()
+	err := run(ctx, capture)
+	if err != nil {
+		step.Warning = fmt.Sprintf("capture stopped with an error: %s", err)
+	}
+
+	for _, e := range capture.Database().Executions() {
+		step.Statements = append(step.Statements, shared.PlannedStatement{Statement: e.Statement, Params: e.Params})
+	}
+
+	return step
+}
+
+// findRollbackMigrations returns applied migrations newest first, either those newer than the named migration
+// or the given number of steps
+func findRollbackMigrations(appliedMigrations []shared.AppliedMigration, name string, steps int) ([]shared.AppliedMigration, error) {
+	if name == "" && steps < 1 {
+		return nil, fmt.Errorf("the number of migrations to roll back must be at least 1, got %d", steps)
+	}
+
+	if name != "" && !migrationIsApplied(name, appliedMigrations) {
+		return nil, fmt.Errorf("migration %s has not been applied", name)
+	}
+
+	out := []shared.AppliedMigration{}
+
+	for {
+		latestMigration := getLatestMigration(appliedMigrations)
+		if latestMigration == nil || latestMigration.Name == name || (name == "" && len(out) >= steps) {
+			break
+		}
+
+		out = append(out, *latestMigration)
+		appliedMigrations = removeAppliedMigration(latestMigration.Name, appliedMigrations)
+	}
+
+	return out, nil
+}
+
+func findMigration(name string, migrations []shared.Migration) *shared.Migration {
+	for _, m := range migrations {
+		if m.Name == name {
+			return &m
+		}
+	}
+
+	return nil
+}
+
+func removeAppliedMigration(name string, appliedMigrations []shared.AppliedMigration) []shared.AppliedMigration {
+	out := []shared.AppliedMigration{}
+
+	for _, am := range appliedMigrations {
+		if am.Name != name {
+			out = append(out, am)
+		}
+	}
+
+	return out
+}
+
+func migrationIsApplied(name string, appliedMigrations []shared.AppliedMigration) bool {
+	for _, appliedMigration := range appliedMigrations {
+		if appliedMigration.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func getLatestMigration(appliedMigrations []shared.AppliedMigration) *shared.AppliedMigration {
+	if len(appliedMigrations) == 0 {
+		return nil
+	}
+
+	out := appliedMigrations[len(appliedMigrations)-1]
+
+	for _, am := range appliedMigrations {
+		if am.Name > out.Name {
+			out = am
+		}
+	}
+
+	return &out
+}
@@ -0,0 +1,416 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cscoding21/csmig/persistence"
+	"github.com/cscoding21/csmig/shared"
+)
+
+func getMemoryConfig(t *testing.T) shared.MigratorConfig {
+	return shared.MigratorConfig{
+		DatabaseStrategyName: "memory",
+		DBConfig:             shared.DatabaseConfig{Database: t.Name()},
+	}
+}
+
+func execMigration(name string, statement string) shared.Migration {
+	return shared.Migration{
+		Name: name,
+		Up: func(ds shared.DatabaseStrategy) error {
+			return ds.Exec(statement, nil)
+		},
+		Down: func(ds shared.DatabaseStrategy) error {
+			return ds.Exec("UNDO "+statement, nil)
+		},
+	}
+}
+
+func TestMigrator(t *testing.T) {
+	config := getMemoryConfig(t)
+	migrator := NewMigrator(config, []shared.Migration{
+		execMigration("m2", "TWO"),
+		execMigration("m1", "ONE"),
+		execMigration("m3", "THREE"),
+	})
+
+	plan, err := migrator.PlanApply(context.Background(), "m2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan) != 2 || plan[0].Name != "m1" || plan[1].Statements[0].Statement != "TWO" {
+		t.Errorf("unexpected plan %+v", plan)
+	}
+
+	err = migrator.ApplyTo(context.Background(), "m2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unapplied, err := migrator.FindUnapplied(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unapplied) != 1 || unapplied[0].Name != "m3" {
+		t.Errorf("unexpected unapplied migrations %v", unapplied)
+	}
+
+	err = migrator.Apply(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//---a rollback of no migrations is a mistake rather than a successful run
+	for _, steps := range []int{0, -1} {
+		if err = migrator.RollbackSteps(context.Background(), steps); err == nil {
+			t.Errorf("expected RollbackSteps to reject %d steps", steps)
+		}
+
+		if _, err = migrator.PlanRollback(context.Background(), "", steps); err == nil {
+			t.Errorf("expected PlanRollback to reject %d steps", steps)
+		}
+	}
+
+	err = migrator.RollbackTo(context.Background(), "m1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := migrator.FindApplied(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 1 || applied[0].Name != "m1" {
+		t.Errorf("unexpected applied migrations %v", applied)
+	}
+
+	executions := persistence.GetMemoryDatabase(config.DBConfig).Executions()
+	if len(executions) != 5 || executions[3].Statement != "UNDO THREE" || executions[4].Statement != "UNDO TWO" {
+		t.Errorf("unexpected executions %v", executions)
+	}
+}
+
+// implicitDDLStrategy a memory strategy whose schema changes are committed implicitly, as they are on MySQL
+type implicitDDLStrategy struct {
+	*persistence.MemoryStrategy
+}
+
+func (s implicitDDLStrategy) TransactionalDDL() bool {
+	return false
+}
+
+func init() {
+	persistence.Register("memory-implicit-ddl", func(config shared.DatabaseConfig) (shared.DatabaseStrategy, error) {
+		return implicitDDLStrategy{persistence.NewMemoryStrategy(config)}, nil
+	})
+}
+
+func TestMigratorDirty(t *testing.T) {
+	tests := []struct {
+		name          string
+		strategy      string
+		noTransaction bool
+		dirty         bool
+	}{
+		{name: "transaction", strategy: "memory", noTransaction: false, dirty: false},
+		{name: "no transaction", strategy: "memory", noTransaction: true, dirty: true},
+		{name: "implicit ddl commit", strategy: "memory-implicit-ddl", noTransaction: false, dirty: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name+" up", func(t *testing.T) {
+			config := getMemoryConfig(t)
+			config.DatabaseStrategyName = tt.strategy
+
+			failing := execMigration("m2", "TWO")
+			failing.NoTransaction = tt.noTransaction
+			failing.Up = func(ds shared.DatabaseStrategy) error {
+				return errors.New("boom")
+			}
+
+			migrator := NewMigrator(config, []shared.Migration{execMigration("m1", "ONE"), failing})
+
+			err := migrator.Apply(context.Background())
+			if err == nil {
+				t.Fatal("expected the failing migration to stop Apply")
+			}
+
+			var migrationErr *shared.MigrationError
+			if !errors.Is(err, shared.ErrMigrationFailed) || !errors.As(err, &migrationErr) || migrationErr.Name != "m2" ||
+				migrationErr.Err.Error() != "boom" || migrationErr.Dirty != tt.dirty {
+				t.Errorf("expected a MigrationError for m2, got %v", err)
+			}
+
+			if shared.ExitCode(err) != shared.ExitMigrationFailed {
+				t.Errorf("unexpected exit code %d", shared.ExitCode(err))
+			}
+
+			applied, err := migrator.FindApplied(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = migrator.Rollback(context.Background())
+
+			//---a migration whose changes are rolled back with its transaction can't be left part way through
+			if !tt.dirty {
+				if len(applied) != 1 || applied[0].Name != "m1" {
+					t.Errorf("expected the failed migration to be rolled back, got %v", applied)
+				}
+
+				if err != nil {
+					t.Errorf("a migration rolled back with its transaction shouldn't block Rollback, got %v", err)
+				}
+
+				return
+			}
+
+			if len(applied) != 2 || !applied[1].Dirty {
+				t.Errorf("expected a dirty record for m2, got %v", applied)
+			}
+
+			if !errors.Is(err, shared.ErrDirty) {
+				t.Errorf("expected a dirty migration to block Rollback, got %v", err)
+			}
+		})
+
+		t.Run(tt.name+" down", func(t *testing.T) {
+			config := getMemoryConfig(t)
+			config.DatabaseStrategyName = tt.strategy
+
+			failing := execMigration("m2", "TWO")
+			failing.NoTransaction = tt.noTransaction
+			failing.Down = func(ds shared.DatabaseStrategy) error {
+				return errors.New("boom")
+			}
+
+			migrator := NewMigrator(config, []shared.Migration{execMigration("m1", "ONE"), failing})
+
+			err := migrator.Apply(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = migrator.Rollback(context.Background())
+
+			var migrationErr *shared.MigrationError
+			if !errors.As(err, &migrationErr) || migrationErr.Name != "m2" || migrationErr.Direction != shared.DirectionDown ||
+				migrationErr.Dirty != tt.dirty {
+				t.Errorf("expected a MigrationError for m2, got %v", err)
+			}
+
+			applied, err := migrator.FindApplied(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(applied) != 2 || applied[1].Name != "m2" || applied[1].Dirty != tt.dirty {
+				t.Errorf("expected m2 to still be recorded with dirty %v, got %v", tt.dirty, applied)
+			}
+
+			err = migrator.Apply(context.Background())
+			if tt.dirty != errors.Is(err, shared.ErrDirty) {
+				t.Errorf("expected a dirty record, and only a dirty record, to block Apply, got %v", err)
+			}
+		})
+	}
+}
+
+func TestMigratorContext(t *testing.T) {
+	config := getMemoryConfig(t)
+	config.MigrationTimeout = 20 * time.Millisecond
+
+	hung := shared.Migration{
+		Name: "m2",
+		UpContext: func(ctx context.Context, ds shared.DatabaseStrategy) error {
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	}
+
+	migrator := NewMigrator(config, []shared.Migration{execMigration("m1", "ONE"), hung})
+
+	err := migrator.Apply(context.Background())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the migration timeout to stop m2, got %v", err)
+	}
+
+	//---migrations written without a context still have their Exec calls cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = execMigration("m3", "THREE").RunUp(ctx, persistence.NewCaptureStrategy())
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected Exec to honour the cancelled context, got %v", err)
+	}
+}
+
+func TestMigratorHooks(t *testing.T) {
+	failing := execMigration("m3", "THREE")
+	failing.Up = func(ds shared.DatabaseStrategy) error {
+		return errors.New("boom")
+	}
+
+	migrator := NewMigrator(getMemoryConfig(t), []shared.Migration{execMigration("m1", "ONE"), execMigration("m2", "TWO")})
+
+	calls := []string{}
+	record := func(event shared.HookEvent) shared.Hook {
+		return func(ctx context.Context, migration shared.Migration, direction shared.Direction, ds shared.DatabaseStrategy) error {
+			calls = append(calls, fmt.Sprintf("%s %s %s %s", event, migration.Name, direction, ds.Name()))
+			return nil
+		}
+	}
+
+	migrator.BeforeAll(record(shared.HookBeforeAll))
+	migrator.AfterAll(record(shared.HookAfterAll))
+	migrator.BeforeEach(record(shared.HookBeforeEach))
+	migrator.AfterEach(record(shared.HookAfterEach))
+	migrator.OnError(func(ctx context.Context, migration shared.Migration, direction shared.Direction, ds shared.DatabaseStrategy, err error) error {
+		calls = append(calls, fmt.Sprintf("%s %s %s %v", shared.HookOnError, migration.Name, direction, errors.Is(err, shared.ErrMigrationFailed)))
+		return nil
+	})
+
+	err := migrator.Apply(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"before_all m1 up memory",
+		"before_each m1 up memory",
+		"after_each m1 up memory",
+		"before_each m2 up memory",
+		"after_each m2 up memory",
+		"after_all m2 up memory",
+	}
+	if !slices.Equal(calls, expected) {
+		t.Errorf("unexpected hook calls %v", calls)
+	}
+
+	//---hooks aren't called when there is nothing to do
+	calls = nil
+	err = migrator.Apply(context.Background())
+	if err != nil || len(calls) != 0 {
+		t.Errorf("expected no hook calls, got %v (%v)", calls, err)
+	}
+
+	err = migrator.Rollback(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(calls) != 4 || calls[0] != "before_all m2 down memory" {
+		t.Errorf("unexpected rollback hook calls %v", calls)
+	}
+
+	//---a failing migration skips the remaining hooks and calls OnError
+	migrator.migrations = append(migrator.migrations, failing)
+	calls = nil
+
+	err = migrator.Apply(context.Background())
+	if !errors.Is(err, shared.ErrMigrationFailed) {
+		t.Fatalf("expected m3 to fail, got %v", err)
+	}
+
+	expected = []string{
+		"before_all m2 up memory",
+		"before_each m2 up memory",
+		"after_each m2 up memory",
+		"before_each m3 up memory",
+		"on_error m3 up true",
+	}
+	if !slices.Equal(calls, expected) {
+		t.Errorf("unexpected hook calls %v", calls)
+	}
+}
+
+func TestMigratorHookErrors(t *testing.T) {
+	migrator := NewMigrator(getMemoryConfig(t), []shared.Migration{execMigration("m1", "ONE")})
+
+	var reported error
+	migrator.BeforeEach(func(ctx context.Context, migration shared.Migration, direction shared.Direction, ds shared.DatabaseStrategy) error {
+		return errors.New("no snapshot")
+	})
+	migrator.OnError(func(ctx context.Context, migration shared.Migration, direction shared.Direction, ds shared.DatabaseStrategy, err error) error {
+		reported = err
+		return errors.New("notify failed")
+	})
+
+	err := migrator.Apply(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "before_each hook failed: no snapshot") ||
+		!strings.Contains(err.Error(), "on_error hook failed: notify failed") {
+		t.Errorf("expected the hook errors to be returned, got %v", err)
+	}
+
+	if reported == nil || !strings.Contains(reported.Error(), "no snapshot") {
+		t.Errorf("expected OnError to receive the hook error, got %v", reported)
+	}
+
+	applied, err := migrator.FindApplied(context.Background())
+	if err != nil || len(applied) != 0 {
+		t.Errorf("a failing BeforeEach hook should stop the migration, got %v (%v)", applied, err)
+	}
+}
+
+func TestMigratorHookCommands(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hook commands are tested with sh")
+	}
+
+	//---the run config is kept from the commands, so it never shows up in their output
+	t.Setenv(shared.RunConfigEnvVar, "config.json")
+
+	out := filepath.Join(t.TempDir(), "hooks.log")
+	line := `echo "$CSMIG_HOOK_EVENT $CSMIG_HOOK_MIGRATION $CSMIG_HOOK_DIRECTION $CSMIG_HOOK_STRATEGY $CSMIG_HOOK_ERROR$` +
+		shared.RunConfigEnvVar + `" >> ` + out
+
+	failing := execMigration("m2", "TWO")
+	failing.Up = func(ds shared.DatabaseStrategy) error {
+		return errors.New("boom")
+	}
+
+	migrator := NewMigrator(getMemoryConfig(t), []shared.Migration{execMigration("m1", "ONE"), failing})
+	migrator.UseHookCommands(shared.HookCommands{
+		BeforeAll: []string{line},
+		AfterEach: []string{line},
+		OnError:   []string{line},
+	})
+
+	err := migrator.Apply(context.Background())
+	if !errors.Is(err, shared.ErrMigrationFailed) {
+		t.Fatalf("expected m2 to fail, got %v", err)
+	}
+
+	contents, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "before_all m1 up memory \n" +
+		"after_each m1 up memory \n" +
+		"on_error m2 up memory migration m2 failed: boom\n"
+	if string(contents) != expected {
+		t.Errorf("unexpected hook command output:\n%s", contents)
+	}
+
+	//---a failing command stops the run
+	config := getMemoryConfig(t)
+	config.DBConfig.Database += "/failing"
+
+	migrator = NewMigrator(config, []shared.Migration{execMigration("m3", "THREE")})
+	migrator.UseHookCommands(shared.HookCommands{BeforeAll: []string{"exit 3"}})
+
+	err = migrator.Apply(context.Background())
+	if err == nil || !strings.Contains(err.Error(), `before_all hook failed: "exit 3": exit status 3`) {
+		t.Errorf("expected the failing command to stop the run, got %v", err)
+	}
+}
@@ -1,50 +1,210 @@
 package migrate
 
 import (
-	"log"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"os/user"
 	"path"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/cscoding21/csmig/shared"
+	"github.com/cscoding21/csmig/version"
 )
 
 // EnsureInfrastructure create the migration table in the target DB if it doesn't exist.
-func EnsureInfrastructure(strategy shared.DatabaseStrategy) error {
-	return strategy.EnsureInfrastructure(strategy.DBConfig)
+func EnsureInfrastructure(ctx context.Context, strategy shared.DatabaseStrategy) error {
+	return strategy.EnsureInfrastructure(ctx)
 }
 
 // ApplyMigration record a migration as being applied in the database
-func ApplyMigration(strategy shared.DatabaseStrategy, name string, description string) error {
-	return strategy.ApplyMigration(strategy.DBConfig, name, description)
+func ApplyMigration(ctx context.Context, strategy shared.DatabaseStrategy, name string, description string) error {
+	return strategy.Apply(ctx, shared.AppliedMigration{Name: name, Description: description})
 }
 
-func FindAppliedMigrations(strategy shared.DatabaseStrategy) ([]shared.AppliedMigration, error) {
-	return strategy.FindAppliedMigrations(strategy.DBConfig)
+// NewAppliedMigration return the version record for a migration that ran for the given duration, including
+// who applied it, from which host and with which version of csmig.
+func NewAppliedMigration(migration shared.Migration, duration time.Duration) shared.AppliedMigration {
+	return shared.AppliedMigration{
+		Name:        migration.Name,
+		Description: migration.Description,
+		Checksum:    migration.Checksum,
+		ExecutionMS: duration.Milliseconds(),
+		AppliedBy:   currentUser(),
+		Hostname:    currentHostname(),
+		ToolVersion: version.Version,
+	}
+}
+
+func FindAppliedMigrations(ctx context.Context, strategy shared.DatabaseStrategy) ([]shared.AppliedMigration, error) {
+	return strategy.FindApplied(ctx)
 }
 
-func RollbackMigration(strategy shared.DatabaseStrategy, name string) error {
-	return strategy.RollbackMigration(strategy.DBConfig, name)
+func RollbackMigration(ctx context.Context, strategy shared.DatabaseStrategy, name string) error {
+	return strategy.Rollback(ctx, name)
 }
 
-// FindDiscoveredMigrationFiles iterated over files in the migratin path and return all created migrations
-func FindDiscoveredMigrationFiles(config shared.MigratorConfig) []shared.Migration {
+// FindDiscoveredMigrationFiles iterated over files in the migratin path and return all created migrations, both
+// generated Go migrations and plain SQL migrations, ordered by name
+func FindDiscoveredMigrationFiles(config shared.MigratorConfig) ([]shared.Migration, error) {
 	migrations := []shared.Migration{}
 
 	files, err := filepath.Glob(path.Join(config.GeneratorPath, "/m*_gen.go"))
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	for _, file := range files {
-		fn := filepath.Base(file)
-		mn := strings.Replace(fn, "_gen.go", "", 1)
-		migrations = append(migrations, shared.Migration{
-			FilePath: file,
-			Package:  config.GeneratorPackage,
-			Name:     mn,
-		})
+		contents, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		migration, err := ReadGoMigration(file, contents)
+		if err != nil {
+			return nil, err
+		}
+
+		migration.Package = config.GeneratorPackage
+		migration.Checksum = Checksum(contents)
+
+		migrations = append(migrations, migration)
+	}
+
+	//---plain SQL migrations are discovered alongside the Go migrations
+	sqlMigrations, err := FindSQLMigrations(config)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations = append(migrations, sqlMigrations...)
+	slices.SortFunc(migrations, func(a, b shared.Migration) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return migrations, nil
+}
+
+// Checksum return the hex encoded sha256 of a migration's source.  Line endings are normalised so that
+// a checkout with CRLF line endings doesn't register as a change.
+func Checksum(source []byte) string {
+	sum := sha256.Sum256(bytes.ReplaceAll(source, []byte("\r\n"), []byte("\n")))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// WithChecksumFromFS return the migration with the checksum of its m*_gen.go source in fsys, the same checksum
+// file discovery computes.  The generated catalog passes the files it embeds, so a compiled package records the
+// checksum of the source it was built from.  A migration whose source can't be read is returned unchanged.
+func WithChecksumFromFS(fsys fs.FS, migration shared.Migration) shared.Migration {
+	contents, err := fs.ReadFile(fsys, migration.Name+"_gen.go")
+	if err != nil {
+		return migration
+	}
+
+	migration.Checksum = Checksum(contents)
+
+	return migration
+}
+
+// FindChecksumMismatches return the applied migrations whose recorded checksum differs from the checksum of
+// the discovered migration with the same name.  Migrations without a checksum on either side, e.g. those
+// applied before checksums were recorded, and applied migrations that weren't discovered are skipped.
+func FindChecksumMismatches(discoveredMigrations []shared.Migration, appliedMigrations []shared.AppliedMigration) []shared.ChecksumMismatch {
+	out := []shared.ChecksumMismatch{}
+
+	for _, am := range appliedMigrations {
+		if am.Checksum == "" {
+			continue
+		}
+
+		for _, dm := range discoveredMigrations {
+			if dm.Name == am.Name && dm.Checksum != "" && dm.Checksum != am.Checksum {
+				out = append(out, shared.ChecksumMismatch{
+					Name:     am.Name,
+					Recorded: am.Checksum,
+					Current:  dm.Checksum,
+				})
+			}
+		}
+	}
+
+	return out
+}
+
+// FindPendingMigrations return the discovered migrations that haven't been applied, in the order they were given.
+func FindPendingMigrations(discoveredMigrations []shared.Migration, appliedMigrations []shared.AppliedMigration) []shared.Migration {
+	out := []shared.Migration{}
+
+	for _, dm := range discoveredMigrations {
+		if !slices.ContainsFunc(appliedMigrations, func(am shared.AppliedMigration) bool { return am.Name == dm.Name }) {
+			out = append(out, dm)
+		}
+	}
+
+	return out
+}
+
+// FindOrphanedMigrations return the applied migrations that have no matching discovered migration, e.g. because
+// their file was deleted or they were applied from another branch.
+func FindOrphanedMigrations(discoveredMigrations []shared.Migration, appliedMigrations []shared.AppliedMigration) []shared.AppliedMigration {
+	out := []shared.AppliedMigration{}
+
+	for _, am := range appliedMigrations {
+		if !slices.ContainsFunc(discoveredMigrations, func(dm shared.Migration) bool { return dm.Name == am.Name }) {
+			out = append(out, am)
+		}
+	}
+
+	return out
+}
+
+// FindOutOfOrderMigrations return the pending migrations that sort before the latest applied migration.  Apply
+// still runs them, but they were most likely created on a branch that was merged after newer migrations shipped.
+func FindOutOfOrderMigrations(discoveredMigrations []shared.Migration, appliedMigrations []shared.AppliedMigration) []shared.Migration {
+	out := []shared.Migration{}
+
+	latest := ""
+	for _, am := range appliedMigrations {
+		latest = max(latest, am.Name)
+	}
+
+	for _, pm := range FindPendingMigrations(discoveredMigrations, appliedMigrations) {
+		if pm.Name < latest {
+			out = append(out, pm)
+		}
+	}
+
+	return out
+}
+
+func currentUser() string {
+	u, err := user.Current()
+	if err == nil && u.Username != "" {
+		return u.Username
+	}
+
+	//---user lookups can fail in minimal containers without an /etc/passwd entry
+	for _, key := range []string{"USER", "USERNAME"} {
+		if name := os.Getenv(key); name != "" {
+			return name
+		}
+	}
+
+	return ""
+}
+
+func currentHostname() string {
+	host, err := os.Hostname()
+	if err != nil {
+		return ""
 	}
 
-	return migrations
+	return host
 }
@@ -1,16 +1,23 @@
 package migrate
 
 import (
+	"context"
 	"fmt"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/cscoding21/csmig/persistence"
 	"github.com/cscoding21/csmig/shared"
+	"github.com/cscoding21/csmig/version"
 )
 
 func TestFindDiscoveredMigrations(t *testing.T) {
 	manifest := shared.GetTestConfig()
-	migrations := FindDiscoveredMigrationFiles(manifest)
+	migrations, err := FindDiscoveredMigrationFiles(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	if len(migrations) == 0 {
 		t.Log("no discovered migrations found.  this may be an error, but not necessarily")
@@ -26,7 +33,7 @@ func TestEnsureInfrastructure(t *testing.T) {
 		t.Error(err)
 	}
 
-	err = EnsureInfrastructure(strategy)
+	err = EnsureInfrastructure(context.Background(), strategy)
 	if err != nil {
 		t.Error(err)
 	}
@@ -39,7 +46,7 @@ func TestApplyMigration(t *testing.T) {
 		t.Error(err)
 	}
 
-	err = ApplyMigration(strategy, "m123", "unit test migration")
+	err = ApplyMigration(context.Background(), strategy, "m123", "unit test migration")
 	if err != nil {
 		t.Error(err)
 	}
@@ -52,7 +59,7 @@ func TestRollbackMigration(t *testing.T) {
 		t.Error(err)
 	}
 
-	err = RollbackMigration(strategy, "m123")
+	err = RollbackMigration(context.Background(), strategy, "m123")
 	if err != nil {
 		t.Error(err)
 	}
@@ -65,7 +72,7 @@ func TestFindApplyMigrations(t *testing.T) {
 		t.Error(err)
 	}
 
-	am, err := FindAppliedMigrations(strategy)
+	am, err := FindAppliedMigrations(context.Background(), strategy)
 	if err != nil {
 		t.Error(err)
 	}
@@ -74,3 +81,163 @@ func TestFindApplyMigrations(t *testing.T) {
 		fmt.Println(m.Name, m.AppliedOn)
 	}
 }
+
+func TestFindChecksumMismatches(t *testing.T) {
+	if Checksum([]byte("a\r\nb\n")) != Checksum([]byte("a\nb\n")) {
+		t.Error("line endings should not change the checksum")
+	}
+
+	discovered := []shared.Migration{
+		{Name: "m1", Checksum: Checksum([]byte("one"))},
+		{Name: "m2", Checksum: Checksum([]byte("two, edited"))},
+		{Name: "m3", Checksum: Checksum([]byte("three"))},
+	}
+	applied := []shared.AppliedMigration{
+		{Name: "m1", Checksum: Checksum([]byte("one"))},
+		{Name: "m2", Checksum: Checksum([]byte("two"))},
+		{Name: "m3"},
+		{Name: "m4", Checksum: Checksum([]byte("four"))},
+	}
+
+	mismatches := FindChecksumMismatches(discovered, applied)
+	if len(mismatches) != 1 || mismatches[0].Name != "m2" || mismatches[0].Current != discovered[1].Checksum {
+		t.Errorf("unexpected mismatches %v", mismatches)
+	}
+}
+
+func TestFindPendingAndOrphanedMigrations(t *testing.T) {
+	discovered := []shared.Migration{{Name: "m1"}, {Name: "m2"}, {Name: "m4"}, {Name: "m5"}}
+	applied := []shared.AppliedMigration{{Name: "m1"}, {Name: "m3"}, {Name: "m4"}}
+
+	pending := FindPendingMigrations(discovered, applied)
+	if len(pending) != 2 || pending[0].Name != "m2" || pending[1].Name != "m5" {
+		t.Errorf("unexpected pending migrations %v", pending)
+	}
+
+	orphaned := FindOrphanedMigrations(discovered, applied)
+	if len(orphaned) != 1 || orphaned[0].Name != "m3" {
+		t.Errorf("unexpected orphaned migrations %v", orphaned)
+	}
+
+	outOfOrder := FindOutOfOrderMigrations(discovered, applied)
+	if len(outOfOrder) != 1 || outOfOrder[0].Name != "m2" {
+		t.Errorf("unexpected out of order migrations %v", outOfOrder)
+	}
+}
+
+func TestReadGoMigration(t *testing.T) {
+	source := `package migrations
+
+import (
+	"github.com/cscoding21/csmig/shared"
+)
+
+var m1 = shared.Migration{
+	Name:          "m1",
+	Description:   "create \"widget\"",
+	NoTransaction: true,
+	Up: func(ds shared.DatabaseStrategy) error {
+		return ds.Exec("DEFINE TABLE widget;", nil)
+	},
+}
+`
+
+	migration, err := ReadGoMigration("migrations/m1_gen.go", []byte(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if migration.Name != "m1" || migration.Description != `create "widget"` || !migration.NoTransaction {
+		t.Errorf("unexpected migration %+v", migration)
+	}
+
+	//---metadata only the compiler can work out is left empty rather than failing discovery
+	computed := `package migrations
+
+import "github.com/cscoding21/csmig/shared"
+
+const description = "create widget"
+
+var m1 = shared.Migration{
+	Name:          "m" + ("1"),
+	Description:   description,
+	NoTransaction: !false,
+}
+`
+
+	migration, err = ReadGoMigration("migrations/m1_gen.go", []byte(computed))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if migration.Name != "m1" || migration.Description != "" || migration.NoTransaction {
+		t.Errorf("unexpected migration %+v", migration)
+	}
+
+	malformed := map[string]string{
+		"syntax":        "package migrations\n\nvar m1 = shared.Migration{",
+		"missing var":   "package migrations\n\nimport \"github.com/cscoding21/csmig/shared\"\n\nvar m2 = shared.Migration{Name: \"m2\"}\n",
+		"wrong name":    "package migrations\n\nimport \"github.com/cscoding21/csmig/shared\"\n\nvar m1 = shared.Migration{Name: \"m2\"}\n",
+		"name constant": "package migrations\n\nimport \"github.com/cscoding21/csmig/shared\"\n\nconst n = \"m1\"\n\nvar m1 = shared.Migration{Name: n}\n",
+	}
+
+	for name, source := range malformed {
+		if _, err := ReadGoMigration("migrations/m1_gen.go", []byte(source)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		} else {
+			t.Log(err)
+		}
+	}
+}
+
+func TestFromFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"m2.up.surql": {Data: []byte("-- second\nDEFINE TABLE widget;")},
+		"m1.up.sql":   {Data: []byte("-- first\n-- csmig:no_transaction\nCREATE INDEX CONCURRENTLY widget_name ON widget (name);")},
+		"m1.down.sql": {Data: []byte("DROP INDEX widget_name;")},
+		"m1_gen.go":   {Data: []byte("package migrations")},
+		"notes.sql":   {Data: []byte("SELECT 1;")},
+		"m3.down.sql": {Data: []byte("orphaned down file")},
+	}
+
+	migrations, err := FromFS(fsys, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(migrations) != 2 || migrations[0].Name != "m1" || migrations[1].Name != "m2" {
+		t.Fatalf("unexpected migrations %v", migrations)
+	}
+
+	if migrations[0].Description != "first" || !migrations[0].NoTransaction || migrations[1].NoTransaction {
+		t.Errorf("unexpected metadata %v", migrations)
+	}
+
+	capture := persistence.NewCaptureStrategy()
+	err = migrations[0].RunDown(context.Background(), capture)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	executions := capture.Database().Executions()
+	if len(executions) != 1 || executions[0].Statement != "DROP INDEX widget_name;" {
+		t.Errorf("unexpected executions %v", executions)
+	}
+
+	err = migrations[1].RunDown(context.Background(), capture)
+	if err == nil {
+		t.Error("a migration without a down file should not roll back")
+	}
+}
+
+func TestNewAppliedMigration(t *testing.T) {
+	am := NewAppliedMigration(shared.Migration{Name: "m1", Description: "first", Checksum: "abc"}, 1500*time.Millisecond)
+
+	if am.Name != "m1" || am.Checksum != "abc" || am.ExecutionMS != 1500 || am.ToolVersion != version.Version {
+		t.Errorf("unexpected applied migration %v", am)
+	}
+
+	if am.Hostname == "" {
+		t.Error("the hostname should have been recorded")
+	}
+}
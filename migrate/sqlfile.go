@@ -0,0 +1,146 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/cscoding21/csmig/shared"
+)
+
+// SQLMigrationExtensions the extensions of plain SQL and SurrealQL migration files.  A migration is a pair of
+// files sharing a name, e.g. m123.up.sql and m123.down.sql.
+var SQLMigrationExtensions = []string{".sql", ".surql"}
+
+// NoTransactionDirective opts a SQL file migration out of running in a transaction when it appears on its own
+// line in the up file.
+const NoTransactionDirective = "-- csmig:no_transaction"
+
+// FindSQLMigrations return the plain SQL and SurrealQL migrations in the migration path, ordered by name.  Their
+// "UpContext" and "DownContext" methods pass the contents of the up and down files to the strategy's ExecContext.
+func FindSQLMigrations(config shared.MigratorConfig) ([]shared.Migration, error) {
+	migrations, err := FromFS(os.DirFS(config.GeneratorPath), ".")
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range migrations {
+		migrations[i].FilePath = path.Join(config.GeneratorPath, migrations[i].FilePath)
+		migrations[i].Package = config.GeneratorPackage
+	}
+
+	return migrations, nil
+}
+
+// FromFS return the plain SQL and SurrealQL migrations in dir of the file system, ordered by name.  Together
+// with //go:embed it lets a binary carry its SQL migrations instead of reading them from the source tree.
+// Go migrations are compiled in and are not read from the file system.
+func FromFS(fsys fs.FS, dir string) ([]shared.Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		//---nothing has been generated yet
+		return []shared.Migration{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := []shared.Migration{}
+
+	for _, entry := range entries {
+		for _, ext := range SQLMigrationExtensions {
+			name, ok := strings.CutSuffix(entry.Name(), ".up"+ext)
+			if !ok || entry.IsDir() {
+				continue
+			}
+
+			if slices.ContainsFunc(migrations, func(m shared.Migration) bool { return m.Name == name }) {
+				return nil, fmt.Errorf("migration %s has more than one up file", name)
+			}
+
+			migration, err := readSQLMigration(fsys, dir, name, ext)
+			if err != nil {
+				return nil, err
+			}
+
+			migrations = append(migrations, migration)
+		}
+	}
+
+	slices.SortFunc(migrations, func(a, b shared.Migration) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return migrations, nil
+}
+
+func readSQLMigration(fsys fs.FS, dir string, name string, ext string) (shared.Migration, error) {
+	upPath := path.Join(dir, name+".up"+ext)
+	up, err := fs.ReadFile(fsys, upPath)
+	if err != nil {
+		return shared.Migration{}, err
+	}
+
+	//---a missing down file is allowed, but the migration can't be rolled back
+	down, err := fs.ReadFile(fsys, path.Join(dir, name+".down"+ext))
+	hasDown := err == nil
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return shared.Migration{}, err
+	}
+
+	migration := shared.Migration{
+		FilePath:      upPath,
+		Name:          name,
+		Description:   sqlDescription(string(up)),
+		NoTransaction: hasNoTransactionDirective(string(up)),
+		Checksum:      Checksum(append(up, down...)),
+		UpContext:     execSQL(string(up)),
+		DownContext:   execSQL(string(down)),
+	}
+
+	if !hasDown {
+		migration.DownContext = func(ctx context.Context, ds shared.DatabaseStrategy) error {
+			return fmt.Errorf("migration %s has no down file and can't be rolled back", name)
+		}
+	}
+
+	return migration, nil
+}
+
+// sqlDescription returns the text of a comment on the first line of an up file.
+func sqlDescription(up string) string {
+	line, _, _ := strings.Cut(up, "\n")
+	line = strings.TrimSpace(line)
+
+	description, ok := strings.CutPrefix(line, "--")
+	if !ok || line == NoTransactionDirective {
+		return ""
+	}
+
+	return strings.TrimSpace(description)
+}
+
+func hasNoTransactionDirective(up string) bool {
+	for _, line := range strings.Split(up, "\n") {
+		if strings.TrimSpace(line) == NoTransactionDirective {
+			return true
+		}
+	}
+
+	return false
+}
+
+func execSQL(statements string) func(context.Context, shared.DatabaseStrategy) error {
+	return func(ctx context.Context, ds shared.DatabaseStrategy) error {
+		if strings.TrimSpace(statements) == "" {
+			return nil
+		}
+
+		return ds.ExecContext(ctx, statements, nil)
+	}
+}
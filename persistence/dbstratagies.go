@@ -1,7 +1,9 @@
 package persistence
 
 import (
-	"errors"
+	"fmt"
+	"sort"
+	"sync"
 
 	"github.com/cscoding21/csmig/shared"
 )
@@ -10,18 +12,56 @@ const (
 	VersionTableName = "csmig_versions"
 )
 
-var persistenceStrategies = map[string]shared.DatabaseStrategy{
-	"surrealdb": SurrealDBStrategy,
+// Factory creates a DatabaseStrategy for the database described by the config.
+type Factory func(config shared.DatabaseConfig) (shared.DatabaseStrategy, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = map[string]Factory{}
+)
+
+// Register makes a persistence strategy available under the provided name.  It is intended to be
+// called from the init function of the package that implements the strategy.  If Register is called
+// twice with the same name or if factory is nil, it panics.
+func Register(name string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	if factory == nil {
+		panic("persistence: Register factory is nil")
+	}
+
+	if _, dup := factories[name]; dup {
+		panic("persistence: Register called twice for strategy " + name)
+	}
+
+	factories[name] = factory
+}
+
+// Strategies returns a sorted list of the names of the registered persistence strategies.
+func Strategies() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
 }
 
 // GetPersistenceStrategy returns the persistence strategy for the given name.
 func GetPersistenceStrategy(config shared.MigratorConfig) (shared.DatabaseStrategy, error) {
-	strategy, ok := persistenceStrategies[config.DatabaseStrategyName]
+	factoriesMu.RLock()
+	factory, ok := factories[config.DatabaseStrategyName]
+	factoriesMu.RUnlock()
+
 	if !ok {
-		return shared.DatabaseStrategy{}, errors.New("unknown persistence strategy")
+		return nil, fmt.Errorf("unknown persistence strategy %q", config.DatabaseStrategyName)
 	}
 
-	strategy.DBConfig = config.DBConfig
-
-	return strategy, nil
+	return factory(config.DBConfig)
 }
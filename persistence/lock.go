@@ -0,0 +1,71 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/cscoding21/csmig/shared"
+)
+
+// LockTableName the table holding the lock record for strategies without advisory locks.
+const LockTableName = "csmig_lock"
+
+const (
+	// DefaultLockTimeout how long Lock waits for the migration lock when no timeout is configured.
+	DefaultLockTimeout = time.Minute
+
+	// DefaultLockStaleAfter the age at which a lock record is taken over when no expiry is configured.
+	DefaultLockStaleAfter = time.Hour
+)
+
+var lockRetryInterval = 250 * time.Millisecond
+
+// pollLock calls tryLock until it acquires the lock, the timeout passes or ctx is done.
+func pollLock(ctx context.Context, timeout time.Duration, tryLock func() (bool, error)) error {
+	if timeout <= 0 {
+		timeout = DefaultLockTimeout
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		acquired, err := tryLock()
+		if err != nil {
+			return err
+		}
+
+		if acquired {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%w: gave up waiting after %s", shared.ErrLocked, timeout)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(lockRetryInterval):
+		}
+	}
+}
+
+// lockCutoff returns the time before which a lock record is considered stale.
+func lockCutoff(staleAfter time.Duration) time.Time {
+	if staleAfter <= 0 {
+		staleAfter = DefaultLockStaleAfter
+	}
+
+	return time.Now().Add(-staleAfter)
+}
+
+// lockOwner identifies this process in lock records.
+func lockOwner() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+
+	return fmt.Sprintf("%s:%d", host, os.Getpid())
+}
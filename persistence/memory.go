@@ -0,0 +1,293 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cscoding21/csmig/shared"
+)
+
+// MemoryExecution records a single call to Exec against the memory strategy.
+type MemoryExecution struct {
+	Statement string
+	Params    map[string]interface{}
+}
+
+// MemoryDatabase holds the applied migrations and Exec history for one in-memory database.
+type MemoryDatabase struct {
+	mu         sync.Mutex
+	sequence   int
+	applied    map[string]memoryVersion
+	executions []MemoryExecution
+
+	//---the strategy holding the migration lock and when it took it
+	lockedBy *MemoryStrategy
+	lockedAt time.Time
+}
+
+type memoryVersion struct {
+	sequence  int
+	migration shared.AppliedMigration
+}
+
+var (
+	_memoryDatabases   = map[string]*MemoryDatabase{}
+	_memoryDatabasesMu sync.Mutex
+)
+
+// MemoryStrategy keeps migration state in process memory.  It never touches a real database
+// and records every Exec call so that tests can assert on the statements a migration issues.
+type MemoryStrategy struct {
+	db *MemoryDatabase
+}
+
+func init() {
+	Register("memory", func(config shared.DatabaseConfig) (shared.DatabaseStrategy, error) {
+		return NewMemoryStrategy(config), nil
+	})
+}
+
+// NewMemoryStrategy returns a memory strategy backed by the in-memory database named by the config.
+func NewMemoryStrategy(config shared.DatabaseConfig) *MemoryStrategy {
+	return &MemoryStrategy{db: GetMemoryDatabase(config)}
+}
+
+// NewCaptureStrategy returns a memory strategy backed by a private database that no other strategy
+// shares.  It is used to capture the statements a migration would execute without running them.
+func NewCaptureStrategy() *MemoryStrategy {
+	return &MemoryStrategy{db: &MemoryDatabase{applied: map[string]memoryVersion{}}}
+}
+
+// Database returns the in-memory database the strategy writes to.
+func (s *MemoryStrategy) Database() *MemoryDatabase {
+	return s.db
+}
+
+// Name returns the name the strategy is registered under.
+func (s *MemoryStrategy) Name() string {
+	return "memory"
+}
+
+// EnsureInfrastructure is a no-op as the in-memory database needs no setup.
+func (s *MemoryStrategy) EnsureInfrastructure(ctx context.Context) error {
+	return nil
+}
+
+// Start records a dirty version record for a migration that is about to run.
+func (s *MemoryStrategy) Start(ctx context.Context, migration shared.AppliedMigration) error {
+	migration.Dirty = true
+
+	return s.insertVersion(migration)
+}
+
+// Apply records a migration as being applied, replacing its dirty record if there is one.
+func (s *MemoryStrategy) Apply(ctx context.Context, migration shared.AppliedMigration) error {
+	migration.Dirty = false
+
+	return s.insertVersion(migration)
+}
+
+func (s *MemoryStrategy) insertVersion(migration shared.AppliedMigration) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if existing, ok := s.db.applied[migration.Name]; ok && !existing.migration.Dirty {
+		return fmt.Errorf("migration %s: %w", migration.Name, shared.ErrAlreadyApplied)
+	}
+
+	migration.AppliedOn = time.Now().UTC()
+
+	s.db.sequence++
+	s.db.applied[migration.Name] = memoryVersion{
+		sequence:  s.db.sequence,
+		migration: migration,
+	}
+
+	return nil
+}
+
+// FindApplied returns all applied migrations in the order they were applied.
+func (s *MemoryStrategy) FindApplied(ctx context.Context) ([]shared.AppliedMigration, error) {
+	return s.db.AppliedMigrations(), nil
+}
+
+// Rollback removes the applied record for a migration.
+func (s *MemoryStrategy) Rollback(ctx context.Context, name string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	delete(s.db.applied, name)
+
+	return nil
+}
+
+// Reset removes all applied records.
+func (s *MemoryStrategy) Reset(ctx context.Context) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	s.db.applied = map[string]memoryVersion{}
+
+	return nil
+}
+
+// Exec records the statement and a copy of its params.
+func (s *MemoryStrategy) Exec(statement string, params map[string]interface{}) error {
+	return s.ExecContext(context.Background(), statement, params)
+}
+
+// ExecContext records the statement and a copy of its params.  Like a real database, it fails once ctx is done.
+func (s *MemoryStrategy) ExecContext(ctx context.Context, statement string, params map[string]interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	var p map[string]interface{}
+	if params != nil {
+		p = make(map[string]interface{}, len(params))
+		for k, v := range params {
+			p[k] = v
+		}
+	}
+
+	s.db.executions = append(s.db.executions, MemoryExecution{Statement: statement, Params: p})
+
+	return nil
+}
+
+// Transaction runs fn against the strategy and restores the applied migrations and recorded
+// executions to their prior state if fn returns an error.
+func (s *MemoryStrategy) Transaction(ctx context.Context, fn func(tx shared.DatabaseStrategy) error) error {
+	s.db.mu.Lock()
+	sequence := s.db.sequence
+	applied := make(map[string]memoryVersion, len(s.db.applied))
+	for k, v := range s.db.applied {
+		applied[k] = v
+	}
+	executions := len(s.db.executions)
+	s.db.mu.Unlock()
+
+	err := fn(s)
+	if err != nil {
+		s.db.mu.Lock()
+		s.db.sequence = sequence
+		s.db.applied = applied
+		s.db.executions = s.db.executions[:min(executions, len(s.db.executions))]
+		s.db.mu.Unlock()
+	}
+
+	return err
+}
+
+// TransactionalDDL reports true as Transaction restores all of the recorded state.
+func (s *MemoryStrategy) TransactionalDDL() bool {
+	return true
+}
+
+// Lock acquires the migration lock for this strategy.  Strategies sharing the database wait for each other.
+func (s *MemoryStrategy) Lock(ctx context.Context, timeout time.Duration, staleAfter time.Duration) error {
+	return pollLock(ctx, timeout, func() (bool, error) {
+		s.db.mu.Lock()
+		defer s.db.mu.Unlock()
+
+		if s.db.lockedBy != nil && s.db.lockedBy != s && s.db.lockedAt.After(lockCutoff(staleAfter)) {
+			return false, nil
+		}
+
+		s.db.lockedBy = s
+		s.db.lockedAt = time.Now()
+
+		return true, nil
+	})
+}
+
+// Unlock releases the migration lock if this strategy holds it.
+func (s *MemoryStrategy) Unlock() error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if s.db.lockedBy == s {
+		s.db.lockedBy = nil
+	}
+
+	return nil
+}
+
+// ForceUnlock releases the migration lock regardless of which strategy holds it.
+func (s *MemoryStrategy) ForceUnlock(ctx context.Context) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	s.db.lockedBy = nil
+
+	return nil
+}
+
+// Close is a no-op so that state survives for later strategies and assertions.
+func (s *MemoryStrategy) Close() error {
+	return nil
+}
+
+// GetMemoryDatabase returns the in-memory database named by the config, creating it on first use.
+// Strategies built from the same config share state, just as they would against a real server.
+func GetMemoryDatabase(config shared.DatabaseConfig) *MemoryDatabase {
+	_memoryDatabasesMu.Lock()
+	defer _memoryDatabasesMu.Unlock()
+
+	key := config.Namespace + "/" + config.Database
+	db, ok := _memoryDatabases[key]
+	if !ok {
+		db = &MemoryDatabase{applied: map[string]memoryVersion{}}
+		_memoryDatabases[key] = db
+	}
+
+	return db
+}
+
+// AppliedMigrations return the migrations recorded as applied, in the order they were applied.
+func (db *MemoryDatabase) AppliedMigrations() []shared.AppliedMigration {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	versions := make([]memoryVersion, 0, len(db.applied))
+	for _, v := range db.applied {
+		versions = append(versions, v)
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].sequence < versions[j].sequence
+	})
+
+	out := make([]shared.AppliedMigration, 0, len(versions))
+	for _, v := range versions {
+		out = append(out, v.migration)
+	}
+
+	return out
+}
+
+// Executions return every statement passed to Exec, in call order.
+func (db *MemoryDatabase) Executions() []MemoryExecution {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	out := make([]MemoryExecution, len(db.executions))
+	copy(out, db.executions)
+
+	return out
+}
+
+// Clear discard all applied migrations and recorded executions.
+func (db *MemoryDatabase) Clear() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.applied = map[string]memoryVersion{}
+	db.executions = nil
+}
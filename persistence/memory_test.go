@@ -0,0 +1,131 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cscoding21/csmig/shared"
+)
+
+func TestMemoryStrategy(t *testing.T) {
+	config := shared.MigratorConfig{
+		DatabaseStrategyName: "memory",
+		DBConfig:             shared.DatabaseConfig{Database: "TestMemoryStrategy"},
+	}
+
+	strategy, err := GetPersistenceStrategy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := strategy.(*MemoryStrategy).Database()
+	defer db.Clear()
+
+	up := func(ds shared.DatabaseStrategy) error {
+		return ds.Exec("DEFINE TABLE widget SCHEMAFULL;", map[string]interface{}{"owner": "test"})
+	}
+
+	err = up(strategy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = strategy.Apply(context.Background(), shared.AppliedMigration{Name: "m1", Description: "first"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = strategy.Apply(context.Background(), shared.AppliedMigration{Name: "m1", Description: "first"})
+	if !errors.Is(err, shared.ErrAlreadyApplied) {
+		t.Errorf("applying the same migration twice should fail with ErrAlreadyApplied, got %v", err)
+	}
+
+	executions := db.Executions()
+	if len(executions) != 1 || executions[0].Statement != "DEFINE TABLE widget SCHEMAFULL;" || executions[0].Params["owner"] != "test" {
+		t.Errorf("unexpected executions %v", executions)
+	}
+
+	//---a second strategy for the same database sees the same state
+	other, _ := GetPersistenceStrategy(config)
+	applied, err := other.FindApplied(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(applied) != 1 || applied[0].Name != "m1" {
+		t.Errorf("unexpected applied migrations %v", applied)
+	}
+
+	err = strategy.Rollback(context.Background(), "m1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(db.AppliedMigrations()) != 0 {
+		t.Error("rollback should have removed the migration")
+	}
+
+	err = strategy.Start(context.Background(), shared.AppliedMigration{Name: "m2", Description: "second"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if applied := db.AppliedMigrations(); len(applied) != 1 || !applied[0].Dirty {
+		t.Errorf("expected a dirty record after Start, got %v", applied)
+	}
+
+	err = strategy.Apply(context.Background(), shared.AppliedMigration{Name: "m2", Description: "second"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if applied := db.AppliedMigrations(); len(applied) != 1 || applied[0].Dirty {
+		t.Errorf("Apply should have completed the dirty record, got %v", applied)
+	}
+}
+
+func TestMemoryLock(t *testing.T) {
+	config := shared.DatabaseConfig{Database: "TestMemoryLock"}
+
+	first := NewMemoryStrategy(config)
+	second := NewMemoryStrategy(config)
+	defer first.Database().Clear()
+
+	err := first.Lock(context.Background(), time.Second, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = second.Lock(context.Background(), 10*time.Millisecond, time.Hour)
+	if !errors.Is(err, shared.ErrLocked) {
+		t.Errorf("expected ErrLocked while the lock is held, got %v", err)
+	}
+
+	//---a lock older than the stale expiry is taken over
+	time.Sleep(5 * time.Millisecond)
+	err = second.Lock(context.Background(), 10*time.Millisecond, time.Millisecond)
+	if err != nil {
+		t.Errorf("a stale lock should be taken over, got %v", err)
+	}
+
+	err = second.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = first.Lock(context.Background(), 10*time.Millisecond, time.Hour)
+	if err != nil {
+		t.Errorf("the lock should be free after Unlock, got %v", err)
+	}
+
+	//---waiting for the lock stops when the context is cancelled
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err = second.Lock(ctx, time.Minute, time.Hour)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the context to stop the wait, got %v", err)
+	}
+}
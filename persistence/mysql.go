@@ -0,0 +1,126 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/cscoding21/csmig/shared"
+	"github.com/go-sql-driver/mysql"
+)
+
+// The "mysql" strategy persists migration versions in a MySQL or MariaDB database.
+func init() {
+	Register("mysql", func(config shared.DatabaseConfig) (shared.DatabaseStrategy, error) {
+		return newSQLStrategy("mysql", config, mysqlDialect), nil
+	})
+}
+
+var mysqlDialect = sqlDialect{
+	driverName:  "mysql",
+	dsn:         getMySQLDSN,
+	placeholder: mysqlPlaceholder,
+	defineVersionTable: fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (
+		id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		description TEXT NOT NULL,
+		applied_on DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+		checksum VARCHAR(64) NOT NULL DEFAULT '',
+		execution_ms BIGINT NOT NULL DEFAULT 0,
+		applied_by VARCHAR(255) NOT NULL DEFAULT '',
+		hostname VARCHAR(255) NOT NULL DEFAULT '',
+		tool_version VARCHAR(64) NOT NULL DEFAULT '',
+		dirty BOOLEAN NOT NULL DEFAULT FALSE,
+		UNIQUE INDEX %s_name_unique (name)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+	`, VersionTableName, VersionTableName),
+	findVersionTable: `SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?;`,
+	addedVersionColumns: []sqlColumn{
+		{name: "checksum", definition: "VARCHAR(64) NOT NULL DEFAULT ''"},
+		{name: "execution_ms", definition: "BIGINT NOT NULL DEFAULT 0"},
+		{name: "applied_by", definition: "VARCHAR(255) NOT NULL DEFAULT ''"},
+		{name: "hostname", definition: "VARCHAR(255) NOT NULL DEFAULT ''"},
+		{name: "tool_version", definition: "VARCHAR(64) NOT NULL DEFAULT ''"},
+		{name: "dirty", definition: "BOOLEAN NOT NULL DEFAULT FALSE"},
+	},
+	tryAdvisoryLock:     mysqlTryAdvisoryLock,
+	releaseAdvisoryLock: mysqlReleaseAdvisoryLock,
+	forceAdvisoryUnlock: mysqlForceAdvisoryUnlock,
+}
+
+// GetMySQLConnection returns a pooled connection to the MySQL database described by the config.  The pool is
+// shared with strategies using the same database, so call release rather than closing it once done.
+func GetMySQLConnection(config shared.DatabaseConfig) (db *sql.DB, release func() error, err error) {
+	return getSQLConnection(mysqlDialect, config)
+}
+
+func getMySQLDSN(config shared.DatabaseConfig) string {
+	cfg := mysql.NewConfig()
+	cfg.User = config.User
+	cfg.Passwd = config.Password
+	cfg.Net = "tcp"
+	cfg.Addr = net.JoinHostPort(config.Host, strconv.Itoa(config.Port))
+	cfg.DBName = config.Database
+	cfg.ParseTime = true
+	cfg.MultiStatements = true
+
+	//---keep applied_on in UTC regardless of the server's default time zone
+	options := url.Values{}
+	options.Set("time_zone", "'+00:00'")
+	for k, v := range config.Options {
+		options.Set(k, v)
+	}
+
+	dsn := cfg.FormatDSN()
+	if strings.Contains(dsn, "?") {
+		return dsn + "&" + options.Encode()
+	}
+
+	return dsn + "?" + options.Encode()
+}
+
+func mysqlPlaceholder(_ int) string {
+	return "?"
+}
+
+// mysqlLockName names the advisory lock after the database holding the version table.  MySQL limits lock
+// names to 64 characters.
+func mysqlLockName(config shared.DatabaseConfig) string {
+	name := VersionTableName + ":" + config.Database
+	if len(name) > 64 {
+		name = name[:64]
+	}
+
+	return name
+}
+
+func mysqlTryAdvisoryLock(ctx context.Context, conn *sql.Conn, config shared.DatabaseConfig) (bool, error) {
+	acquired := sql.NullInt64{}
+	err := conn.QueryRowContext(ctx, `SELECT GET_LOCK(?, 0);`, mysqlLockName(config)).Scan(&acquired)
+
+	return acquired.Valid && acquired.Int64 == 1, err
+}
+
+func mysqlReleaseAdvisoryLock(conn *sql.Conn, config shared.DatabaseConfig) error {
+	_, err := conn.ExecContext(context.Background(), `SELECT RELEASE_LOCK(?);`, mysqlLockName(config))
+
+	return err
+}
+
+// mysqlForceAdvisoryUnlock kills the connection holding the lock, named locks can only be released by their own session.
+func mysqlForceAdvisoryUnlock(ctx context.Context, db *sql.DB, config shared.DatabaseConfig) error {
+	holder := sql.NullInt64{}
+	err := db.QueryRowContext(ctx, `SELECT IS_USED_LOCK(?);`, mysqlLockName(config)).Scan(&holder)
+	if err != nil || !holder.Valid {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, fmt.Sprintf("KILL %d;", holder.Int64))
+
+	return err
+}
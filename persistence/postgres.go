@@ -1 +1,125 @@
 package persistence
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/url"
+	"strconv"
+
+	"github.com/cscoding21/csmig/shared"
+	_ "github.com/lib/pq"
+)
+
+// The "postgres" strategy persists migration versions in a PostgreSQL database.  The config's
+// Namespace property selects the schema that holds the version table.
+func init() {
+	Register("postgres", func(config shared.DatabaseConfig) (shared.DatabaseStrategy, error) {
+		return newSQLStrategy("postgres", config, postgresDialect), nil
+	})
+}
+
+var postgresDialect = sqlDialect{
+	driverName:  "postgres",
+	dsn:         getPostgresDSN,
+	placeholder: postgresPlaceholder,
+	defineVersionTable: fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (
+		id SERIAL PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		applied_on TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		checksum VARCHAR(64) NOT NULL DEFAULT '',
+		execution_ms BIGINT NOT NULL DEFAULT 0,
+		applied_by VARCHAR(255) NOT NULL DEFAULT '',
+		hostname VARCHAR(255) NOT NULL DEFAULT '',
+		tool_version VARCHAR(64) NOT NULL DEFAULT '',
+		dirty BOOLEAN NOT NULL DEFAULT FALSE,
+		CONSTRAINT %s_name_unique UNIQUE (name)
+	);
+	`, VersionTableName, VersionTableName),
+	findVersionTable: `SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = $1;`,
+	transactionalDDL: true,
+	addedVersionColumns: []sqlColumn{
+		{name: "checksum", definition: "VARCHAR(64) NOT NULL DEFAULT ''"},
+		{name: "execution_ms", definition: "BIGINT NOT NULL DEFAULT 0"},
+		{name: "applied_by", definition: "VARCHAR(255) NOT NULL DEFAULT ''"},
+		{name: "hostname", definition: "VARCHAR(255) NOT NULL DEFAULT ''"},
+		{name: "tool_version", definition: "VARCHAR(64) NOT NULL DEFAULT ''"},
+		{name: "dirty", definition: "BOOLEAN NOT NULL DEFAULT FALSE"},
+	},
+	tryAdvisoryLock:     postgresTryAdvisoryLock,
+	releaseAdvisoryLock: postgresReleaseAdvisoryLock,
+	forceAdvisoryUnlock: postgresForceAdvisoryUnlock,
+}
+
+// GetPostgresConnection returns a pooled connection to the PostgreSQL database described by the config.  The pool is
+// shared with strategies using the same database, so call release rather than closing it once done.
+func GetPostgresConnection(config shared.DatabaseConfig) (db *sql.DB, release func() error, err error) {
+	return getSQLConnection(postgresDialect, config)
+}
+
+func getPostgresDSN(config shared.DatabaseConfig) string {
+	query := url.Values{}
+	for k, v := range config.Options {
+		query.Set(k, v)
+	}
+
+	//---the namespace maps to the schema that holds the version table
+	if config.Namespace != "" && query.Get("search_path") == "" {
+		query.Set("search_path", config.Namespace)
+	}
+
+	if query.Get("sslmode") == "" {
+		query.Set("sslmode", "disable")
+	}
+
+	dsn := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(config.User, config.Password),
+		Host:     config.Host + ":" + strconv.Itoa(config.Port),
+		Path:     "/" + config.Database,
+		RawQuery: query.Encode(),
+	}
+
+	return dsn.String()
+}
+
+func postgresPlaceholder(position int) string {
+	return "$" + strconv.Itoa(position)
+}
+
+// postgresLockKey derives the advisory lock key from the schema holding the version table, so projects
+// sharing a database but not a schema don't block each other.
+func postgresLockKey(config shared.DatabaseConfig) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(config.Namespace + "." + VersionTableName))
+
+	return int64(h.Sum64() & math.MaxInt64)
+}
+
+func postgresTryAdvisoryLock(ctx context.Context, conn *sql.Conn, config shared.DatabaseConfig) (bool, error) {
+	acquired := false
+	err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1);`, postgresLockKey(config)).Scan(&acquired)
+
+	return acquired, err
+}
+
+func postgresReleaseAdvisoryLock(conn *sql.Conn, config shared.DatabaseConfig) error {
+	_, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1);`, postgresLockKey(config))
+
+	return err
+}
+
+// postgresForceAdvisoryUnlock terminates the session holding the lock, advisory locks can only be released by
+// their own session.
+func postgresForceAdvisoryUnlock(ctx context.Context, db *sql.DB, config shared.DatabaseConfig) error {
+	_, err := db.ExecContext(ctx, `
+	SELECT pg_terminate_backend(pid) FROM pg_locks
+	WHERE locktype = 'advisory' AND ((classid::bigint << 32) | objid::bigint) = $1 AND pid <> pg_backend_pid();
+	`, postgresLockKey(config))
+
+	return err
+}
@@ -0,0 +1,715 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cscoding21/csmig/shared"
+)
+
+// sqlDialect captures what differs between the database/sql backed strategies.
+type sqlDialect struct {
+	driverName         string
+	dsn                func(shared.DatabaseConfig) string
+	placeholder        func(int) string
+	defineVersionTable string
+
+	//---counts the tables named by the first parameter in the schema the version table is created in
+	findVersionTable string
+
+	//---whether DDL statements are rolled back with a transaction rather than committing it implicitly
+	transactionalDDL bool
+
+	//---columns added to the version table after its first release, see upgradeVersionTable
+	addedVersionColumns []sqlColumn
+
+	//---maximum number of open connections, zero leaves the database/sql default
+	maxOpenConns int
+
+	//---advisory lock functions, dialects without them keep a lock record in defineLockTable instead
+	tryAdvisoryLock     func(ctx context.Context, conn *sql.Conn, config shared.DatabaseConfig) (bool, error)
+	releaseAdvisoryLock func(conn *sql.Conn, config shared.DatabaseConfig) error
+	forceAdvisoryUnlock func(ctx context.Context, db *sql.DB, config shared.DatabaseConfig) error
+	defineLockTable     string
+}
+
+// sqlColumn a column definition used to upgrade version tables created by older releases.
+type sqlColumn struct {
+	name       string
+	definition string
+}
+
+// sqlStrategy stores migration versions through database/sql using a dialect.
+type sqlStrategy struct {
+	name    string
+	config  shared.DatabaseConfig
+	dialect sqlDialect
+
+	mu sync.Mutex
+	db *sql.DB
+	tx *sql.Tx
+
+	//---the session holding an advisory lock, advisory locks belong to the connection that took them
+	lockConn *sql.Conn
+}
+
+// sqlExecutor is satisfied by both *sql.DB and *sql.Tx.
+type sqlExecutor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+type sqlPool struct {
+	db   *sql.DB
+	refs int
+}
+
+var (
+	_sqlPools   = map[string]*sqlPool{}
+	_sqlPoolsMu sync.Mutex
+)
+
+// newSQLStrategy builds a DatabaseStrategy that stores migration versions through database/sql using the given dialect.
+func newSQLStrategy(name string, config shared.DatabaseConfig, dialect sqlDialect) *sqlStrategy {
+	return &sqlStrategy{
+		name:    name,
+		config:  config,
+		dialect: dialect,
+	}
+}
+
+// Name returns the name the strategy is registered under.
+func (s *sqlStrategy) Name() string {
+	return s.name
+}
+
+// EnsureInfrastructure creates the version table if it doesn't exist.
+func (s *sqlStrategy) EnsureInfrastructure(ctx context.Context) error {
+	db, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	return s.defineVersionTable(ctx, db)
+}
+
+// Start records a dirty version record for a migration that is about to run.
+func (s *sqlStrategy) Start(ctx context.Context, migration shared.AppliedMigration) error {
+	db, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	return s.insertVersion(ctx, db, migration, true)
+}
+
+// Apply records a migration as being applied, replacing its dirty record if there is one.
+func (s *sqlStrategy) Apply(ctx context.Context, migration shared.AppliedMigration) error {
+	db, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	cleanSQL := fmt.Sprintf(`DELETE FROM %s WHERE name = %s AND dirty = %s;`,
+		VersionTableName, s.dialect.placeholder(1), s.dialect.placeholder(2))
+
+	_, err = db.ExecContext(ctx, cleanSQL, migration.Name, true)
+	if err != nil {
+		return err
+	}
+
+	return s.insertVersion(ctx, db, migration, false)
+}
+
+// FindApplied returns all applied migrations in the order they were applied.  It only reads the version table,
+// which EnsureInfrastructure creates and upgrades, so it works for database users without DDL privileges.
+func (s *sqlStrategy) FindApplied(ctx context.Context) ([]shared.AppliedMigration, error) {
+	db, err := s.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	//---a missing version table simply means nothing has been applied yet
+	var tables int
+	err = db.QueryRowContext(ctx, s.dialect.findVersionTable, VersionTableName).Scan(&tables)
+	if err != nil {
+		return nil, err
+	}
+
+	if tables == 0 {
+		return []shared.AppliedMigration{}, nil
+	}
+
+	//---a table from an older release that hasn't been upgraded yet lacks some columns, which keep their defaults
+	columns, err := s.versionColumns(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, field := range versionFields(&shared.AppliedMigration{}) {
+		if slices.ContainsFunc(columns, func(c string) bool { return strings.EqualFold(c, field.name) }) {
+			names = append(names, field.name)
+		}
+	}
+
+	findSQL := fmt.Sprintf(`SELECT %s FROM %s ORDER BY applied_on ASC, id ASC;`, strings.Join(names, ", "), VersionTableName)
+	rows, err := db.QueryContext(ctx, findSQL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	appliedMigrations := []shared.AppliedMigration{}
+	for rows.Next() {
+		am := shared.AppliedMigration{}
+
+		dest := []interface{}{}
+		for _, field := range versionFields(&am) {
+			if slices.Contains(names, field.name) {
+				dest = append(dest, field.dest)
+			}
+		}
+
+		err = rows.Scan(dest...)
+		if err != nil {
+			return nil, err
+		}
+
+		appliedMigrations = append(appliedMigrations, am)
+	}
+
+	return appliedMigrations, rows.Err()
+}
+
+// Rollback removes the applied record for a migration.
+func (s *sqlStrategy) Rollback(ctx context.Context, name string) error {
+	db, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	rollbackSQL := fmt.Sprintf(`DELETE FROM %s WHERE name = %s;`, VersionTableName, s.dialect.placeholder(1))
+
+	_, err = db.ExecContext(ctx, rollbackSQL, name)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Reset removes all applied records.
+func (s *sqlStrategy) Reset(ctx context.Context) error {
+	db, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	resetSQL := fmt.Sprintf(`DELETE FROM %s;`, VersionTableName)
+
+	_, err = db.ExecContext(ctx, resetSQL)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Exec runs a statement against the database with a background context.
+func (s *sqlStrategy) Exec(statement string, params map[string]interface{}) error {
+	return s.ExecContext(context.Background(), statement, params)
+}
+
+// ExecContext runs a statement against the database, binding the named $params to driver placeholders.
+func (s *sqlStrategy) ExecContext(ctx context.Context, statement string, params map[string]interface{}) error {
+	db, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	bound, args, err := bindParams(statement, params, s.dialect.placeholder)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, bound, args...)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// TransactionalDDL reports whether the dialect rolls back DDL statements with a transaction.
+func (s *sqlStrategy) TransactionalDDL() bool {
+	return s.dialect.transactionalDDL
+}
+
+// Transaction runs fn with a strategy bound to a database transaction.  The transaction is committed when fn
+// returns nil and rolled back otherwise.  MySQL implicitly commits DDL statements, so a failed migration
+// that creates or alters tables can only be partly rolled back there.
+func (s *sqlStrategy) Transaction(ctx context.Context, fn func(tx shared.DatabaseStrategy) error) (err error) {
+	if s.tx != nil {
+		return fn(s)
+	}
+
+	executor, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	tx, err := executor.(*sql.DB).BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	err = fn(&sqlStrategy{name: s.name, config: s.config, dialect: s.dialect, tx: tx})
+	if err != nil {
+		//---database/sql has already rolled back a transaction whose context was cancelled
+		if rollbackErr := tx.Rollback(); rollbackErr != nil && !errors.Is(rollbackErr, sql.ErrTxDone) {
+			return errors.Join(err, rollbackErr)
+		}
+
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Lock acquires the migration lock.  Dialects with advisory locks hold one on a dedicated connection until
+// Unlock, other dialects insert a lock record.
+func (s *sqlStrategy) Lock(ctx context.Context, timeout time.Duration, staleAfter time.Duration) error {
+	if s.tx != nil {
+		return errors.New("the migration lock can't be acquired inside a transaction")
+	}
+
+	executor, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+	db := executor.(*sql.DB)
+
+	if s.dialect.tryAdvisoryLock == nil {
+		return s.lockRecord(ctx, db, timeout, staleAfter)
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = pollLock(ctx, timeout, func() (bool, error) {
+		return s.dialect.tryAdvisoryLock(ctx, conn, s.config)
+	})
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	s.lockConn = conn
+
+	return nil
+}
+
+// Unlock releases the migration lock held by this strategy.
+func (s *sqlStrategy) Unlock() error {
+	if s.lockConn != nil {
+		conn := s.lockConn
+		s.lockConn = nil
+
+		err := s.dialect.releaseAdvisoryLock(conn, s.config)
+
+		return errors.Join(err, conn.Close())
+	}
+
+	if s.dialect.tryAdvisoryLock != nil {
+		return nil
+	}
+
+	db, err := s.connect(context.Background())
+	if err != nil {
+		return err
+	}
+
+	unlockSQL := fmt.Sprintf(`DELETE FROM %s WHERE id = 1 AND owner = %s;`, LockTableName, s.dialect.placeholder(1))
+	_, err = db.ExecContext(context.Background(), unlockSQL, lockOwner())
+
+	return err
+}
+
+// ForceUnlock releases the migration lock regardless of who holds it.  For advisory locks this terminates
+// the database session holding the lock.
+func (s *sqlStrategy) ForceUnlock(ctx context.Context) error {
+	executor, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	if s.dialect.forceAdvisoryUnlock != nil {
+		return s.dialect.forceAdvisoryUnlock(ctx, executor.(*sql.DB), s.config)
+	}
+
+	_, err = executor.ExecContext(ctx, s.dialect.defineLockTable)
+	if err != nil {
+		return err
+	}
+
+	_, err = executor.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s;`, LockTableName))
+
+	return err
+}
+
+// lockRecord acquires the lock by inserting a single row with a fixed id, whoever inserts it holds the lock.
+func (s *sqlStrategy) lockRecord(ctx context.Context, db *sql.DB, timeout time.Duration, staleAfter time.Duration) error {
+	_, err := db.ExecContext(ctx, s.dialect.defineLockTable)
+	if err != nil {
+		return err
+	}
+
+	staleSQL := fmt.Sprintf(`DELETE FROM %s WHERE locked_at < %s;`, LockTableName, s.dialect.placeholder(1))
+	lockSQL := fmt.Sprintf(`INSERT INTO %s (id, owner, locked_at) SELECT 1, %s, %s WHERE NOT EXISTS (SELECT 1 FROM %s WHERE id = 1);`,
+		LockTableName, s.dialect.placeholder(1), s.dialect.placeholder(2), LockTableName)
+	owner := lockOwner()
+
+	return pollLock(ctx, timeout, func() (bool, error) {
+		_, err := db.ExecContext(ctx, staleSQL, lockCutoff(staleAfter).Unix())
+		if err != nil {
+			return false, err
+		}
+
+		result, err := db.ExecContext(ctx, lockSQL, owner, time.Now().Unix())
+		if err != nil {
+			return false, err
+		}
+
+		rows, err := result.RowsAffected()
+
+		return rows == 1, err
+	})
+}
+
+// Close releases the strategy's hold on its connection pool.  The pool is closed once no strategy uses it.
+func (s *sqlStrategy) Close() error {
+	if s.lockConn != nil {
+		s.Unlock()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.db == nil {
+		return nil
+	}
+
+	s.db = nil
+
+	return releaseSQLConnection(s.dialect, s.config)
+}
+
+func (s *sqlStrategy) insertVersion(ctx context.Context, db sqlExecutor, migration shared.AppliedMigration, dirty bool) error {
+	//---checked up front because each driver reports a unique index violation differently
+	appliedSQL := fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE name = %s AND dirty = %s;`,
+		VersionTableName, s.dialect.placeholder(1), s.dialect.placeholder(2))
+
+	var count int
+	err := db.QueryRowContext(ctx, appliedSQL, migration.Name, false).Scan(&count)
+	if err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return fmt.Errorf("migration %s: %w", migration.Name, shared.ErrAlreadyApplied)
+	}
+
+	insertSQL := fmt.Sprintf(`
+	INSERT INTO %s (name, description, checksum, execution_ms, applied_by, hostname, tool_version, dirty)
+	VALUES (%s, %s, %s, %s, %s, %s, %s, %s);`,
+		VersionTableName, s.dialect.placeholder(1), s.dialect.placeholder(2), s.dialect.placeholder(3),
+		s.dialect.placeholder(4), s.dialect.placeholder(5), s.dialect.placeholder(6), s.dialect.placeholder(7),
+		s.dialect.placeholder(8))
+
+	_, err = db.ExecContext(ctx, insertSQL, migration.Name, migration.Description, migration.Checksum,
+		migration.ExecutionMS, migration.AppliedBy, migration.Hostname, migration.ToolVersion, dirty)
+
+	return err
+}
+
+// defineVersionTable creates the version table, or upgrades one created by an older release by adding the
+// columns it is missing.
+func (s *sqlStrategy) defineVersionTable(ctx context.Context, db sqlExecutor) error {
+	_, err := db.ExecContext(ctx, s.dialect.defineVersionTable)
+	if err != nil {
+		return err
+	}
+
+	columns, err := s.versionColumns(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	for _, column := range s.dialect.addedVersionColumns {
+		if slices.ContainsFunc(columns, func(c string) bool { return strings.EqualFold(c, column.name) }) {
+			continue
+		}
+
+		_, err = db.ExecContext(ctx, fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s;`, VersionTableName, column.name, column.definition))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// versionColumns returns the names of the version table's columns.
+func (s *sqlStrategy) versionColumns(ctx context.Context, db sqlExecutor) ([]string, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT * FROM %s WHERE 1 = 0;`, VersionTableName))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return rows.Columns()
+}
+
+// sqlField a version table column and where its value is scanned to.
+type sqlField struct {
+	name string
+	dest interface{}
+}
+
+// versionFields returns the version table columns read into an applied migration, in select order.
+func versionFields(am *shared.AppliedMigration) []sqlField {
+	return []sqlField{
+		{name: "name", dest: &am.Name},
+		{name: "description", dest: &am.Description},
+		{name: "applied_on", dest: &am.AppliedOn},
+		{name: "checksum", dest: &am.Checksum},
+		{name: "dirty", dest: &am.Dirty},
+		{name: "execution_ms", dest: &am.ExecutionMS},
+		{name: "applied_by", dest: &am.AppliedBy},
+		{name: "hostname", dest: &am.Hostname},
+		{name: "tool_version", dest: &am.ToolVersion},
+	}
+}
+
+func (s *sqlStrategy) connect(ctx context.Context) (sqlExecutor, error) {
+	if s.tx != nil {
+		return s.tx, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.db != nil {
+		return s.db, nil
+	}
+
+	db, err := acquireSQLConnection(ctx, s.dialect, s.config)
+	if err != nil {
+		return nil, err
+	}
+
+	s.db = db
+
+	return db, nil
+}
+
+// getSQLConnection returns the shared pool for the dialect and config together with the function that releases
+// the caller's hold on it.  The pool stays open until every strategy and caller using it has released it.
+func getSQLConnection(dialect sqlDialect, config shared.DatabaseConfig) (*sql.DB, func() error, error) {
+	db, err := acquireSQLConnection(context.Background(), dialect, config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var once sync.Once
+	release := func() (err error) {
+		once.Do(func() { err = releaseSQLConnection(dialect, config) })
+		return err
+	}
+
+	return db, release, nil
+}
+
+// acquireSQLConnection returns the shared pool for the dialect and config and counts the caller as a user of it.
+// The pool is closed when the last user releases it.
+func acquireSQLConnection(ctx context.Context, dialect sqlDialect, config shared.DatabaseConfig) (*sql.DB, error) {
+	_sqlPoolsMu.Lock()
+	defer _sqlPoolsMu.Unlock()
+
+	pool, err := openSQLPool(ctx, dialect, config)
+	if err != nil {
+		return nil, err
+	}
+
+	pool.refs++
+
+	return pool.db, nil
+}
+
+func releaseSQLConnection(dialect sqlDialect, config shared.DatabaseConfig) error {
+	_sqlPoolsMu.Lock()
+	defer _sqlPoolsMu.Unlock()
+
+	key := sqlPoolKey(dialect, config)
+	pool, ok := _sqlPools[key]
+	if !ok {
+		return nil
+	}
+
+	pool.refs--
+	if pool.refs > 0 {
+		return nil
+	}
+
+	delete(_sqlPools, key)
+
+	return pool.db.Close()
+}
+
+func openSQLPool(ctx context.Context, dialect sqlDialect, config shared.DatabaseConfig) (*sqlPool, error) {
+	key := sqlPoolKey(dialect, config)
+	if pool, ok := _sqlPools[key]; ok {
+		return pool, nil
+	}
+
+	db, err := sql.Open(dialect.driverName, dialect.dsn(config))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", shared.ErrConnection, err)
+	}
+
+	if dialect.maxOpenConns > 0 {
+		db.SetMaxOpenConns(dialect.maxOpenConns)
+	}
+
+	if err = db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%w: %w", shared.ErrConnection, err)
+	}
+
+	pool := &sqlPool{db: db}
+	_sqlPools[key] = pool
+
+	return pool, nil
+}
+
+func sqlPoolKey(dialect sqlDialect, config shared.DatabaseConfig) string {
+	return dialect.driverName + "|" + dialect.dsn(config)
+}
+
+// bindParams rewrites the $name style parameters used throughout csmig into the placeholders
+// expected by a database/sql driver and returns the matching argument list.  Parameters inside
+// quoted strings, identifiers, comments and dollar-quoted bodies are left untouched.
+func bindParams(statement string, params map[string]interface{}, placeholder func(int) string) (string, []interface{}, error) {
+	return rewriteParams(statement, params, placeholder, true)
+}
+
+// rewriteParams does the work for bindParams.  When strict is false, parameters without a value are
+// left in place rather than treated as an error.
+func rewriteParams(statement string, params map[string]interface{}, placeholder func(int) string, strict bool) (string, []interface{}, error) {
+	if len(params) == 0 {
+		return statement, nil, nil
+	}
+
+	var out strings.Builder
+	args := []interface{}{}
+	n := len(statement)
+
+	for i := 0; i < n; {
+		c := statement[i]
+
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			end := strings.IndexByte(statement[i+1:], c)
+			if end < 0 {
+				end = n
+			} else {
+				end = i + 1 + end + 1
+			}
+			out.WriteString(statement[i:end])
+			i = end
+		case c == '-' && i+1 < n && statement[i+1] == '-':
+			end := strings.IndexByte(statement[i:], '\n')
+			if end < 0 {
+				end = n
+			} else {
+				end = i + end
+			}
+			out.WriteString(statement[i:end])
+			i = end
+		case c == '/' && i+1 < n && statement[i+1] == '*':
+			end := strings.Index(statement[i+2:], "*/")
+			if end < 0 {
+				end = n
+			} else {
+				end = i + 2 + end + 2
+			}
+			out.WriteString(statement[i:end])
+			i = end
+		case c == '$' && i+1 < n && (statement[i+1] == '$' || isIdentStart(statement[i+1])):
+			j := i + 1
+			for j < n && isIdentChar(statement[j]) {
+				j++
+			}
+
+			//---$tag$ ... $tag$ is a dollar-quoted body rather than a parameter
+			if j < n && statement[j] == '$' {
+				tag := statement[i : j+1]
+				end := strings.Index(statement[j+1:], tag)
+				if end < 0 {
+					end = n
+				} else {
+					end = j + 1 + end + len(tag)
+				}
+				out.WriteString(statement[i:end])
+				i = end
+				continue
+			}
+
+			name := statement[i+1 : j]
+			value, ok := params[name]
+			if !ok && strict {
+				return "", nil, fmt.Errorf("no value supplied for parameter $%s", name)
+			}
+
+			if !ok {
+				out.WriteString(statement[i:j])
+				i = j
+				continue
+			}
+
+			args = append(args, value)
+			out.WriteString(placeholder(len(args)))
+			i = j
+		default:
+			out.WriteByte(c)
+			i++
+		}
+	}
+
+	return out.String(), args, nil
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || (c >= '0' && c <= '9')
+}
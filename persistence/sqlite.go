@@ -0,0 +1,82 @@
+package persistence
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+
+	"github.com/cscoding21/csmig/shared"
+	_ "modernc.org/sqlite"
+)
+
+// The "sqlite" strategy persists migration versions in an embedded SQLite database.  The config's
+// Database property is the path to the database file, or ":memory:" for a transient database.
+func init() {
+	Register("sqlite", func(config shared.DatabaseConfig) (shared.DatabaseStrategy, error) {
+		return newSQLStrategy("sqlite", config, sqliteDialect), nil
+	})
+}
+
+var sqliteDialect = sqlDialect{
+	driverName:  "sqlite",
+	dsn:         getSQLiteDSN,
+	placeholder: sqlitePlaceholder,
+	defineVersionTable: fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		applied_on DATETIME NOT NULL DEFAULT (strftime('%%Y-%%m-%%d %%H:%%M:%%f', 'now')),
+		checksum VARCHAR(64) NOT NULL DEFAULT '',
+		execution_ms BIGINT NOT NULL DEFAULT 0,
+		applied_by VARCHAR(255) NOT NULL DEFAULT '',
+		hostname VARCHAR(255) NOT NULL DEFAULT '',
+		tool_version VARCHAR(64) NOT NULL DEFAULT '',
+		dirty BOOLEAN NOT NULL DEFAULT FALSE,
+		CONSTRAINT %s_name_unique UNIQUE (name)
+	);
+	`, VersionTableName, VersionTableName),
+	findVersionTable: `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?;`,
+	transactionalDDL: true,
+	addedVersionColumns: []sqlColumn{
+		{name: "checksum", definition: "VARCHAR(64) NOT NULL DEFAULT ''"},
+		{name: "execution_ms", definition: "BIGINT NOT NULL DEFAULT 0"},
+		{name: "applied_by", definition: "VARCHAR(255) NOT NULL DEFAULT ''"},
+		{name: "hostname", definition: "VARCHAR(255) NOT NULL DEFAULT ''"},
+		{name: "tool_version", definition: "VARCHAR(64) NOT NULL DEFAULT ''"},
+		{name: "dirty", definition: "BOOLEAN NOT NULL DEFAULT FALSE"},
+	},
+
+	defineLockTable: fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (
+		id INTEGER PRIMARY KEY,
+		owner TEXT NOT NULL,
+		locked_at INTEGER NOT NULL
+	);
+	`, LockTableName),
+	//---SQLite allows a single writer and every ":memory:" connection is its own database
+	maxOpenConns: 1,
+}
+
+// GetSQLiteConnection returns a connection to the SQLite database file described by the config.  The pool is
+// shared with strategies using the same database, so call release rather than closing it once done.
+func GetSQLiteConnection(config shared.DatabaseConfig) (db *sql.DB, release func() error, err error) {
+	return getSQLConnection(sqliteDialect, config)
+}
+
+func getSQLiteDSN(config shared.DatabaseConfig) string {
+	if len(config.Options) == 0 {
+		return config.Database
+	}
+
+	options := url.Values{}
+	for k, v := range config.Options {
+		options.Add(k, v)
+	}
+
+	return fmt.Sprintf("file:%s?%s", config.Database, options.Encode())
+}
+
+func sqlitePlaceholder(_ int) string {
+	return "?"
+}
@@ -0,0 +1,415 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cscoding21/csmig/shared"
+)
+
+func TestSQLiteVersionLifecycle(t *testing.T) {
+	config := shared.MigratorConfig{
+		DatabaseStrategyName: "sqlite",
+		DBConfig: shared.DatabaseConfig{
+			Database: filepath.Join(t.TempDir(), "csmig.db"),
+		},
+	}
+
+	strategy, err := GetPersistenceStrategy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer strategy.Close()
+
+	err = strategy.EnsureInfrastructure(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = strategy.Exec("CREATE TABLE widget (id INTEGER PRIMARY KEY, name TEXT NOT NULL);", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = strategy.Exec("INSERT INTO widget (name) VALUES ($name);", map[string]interface{}{"name": "sprocket"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"m1", "m2"} {
+		err = strategy.Apply(context.Background(), shared.AppliedMigration{Name: name, Description: "migration " + name, Checksum: "sum-" + name})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = strategy.Apply(context.Background(), shared.AppliedMigration{Name: "m1", Description: "duplicate"})
+	if !errors.Is(err, shared.ErrAlreadyApplied) {
+		t.Errorf("applying the same migration twice should fail with ErrAlreadyApplied, got %v", err)
+	}
+
+	applied, err := strategy.FindApplied(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(applied) != 2 || applied[0].Name != "m1" || applied[1].Name != "m2" {
+		t.Errorf("unexpected applied migrations %v", applied)
+	}
+
+	if applied[0].AppliedOn.IsZero() {
+		t.Error("applied_on should have been populated")
+	}
+
+	if applied[0].Checksum != "sum-m1" {
+		t.Errorf("expected the checksum to be recorded, got %q", applied[0].Checksum)
+	}
+
+	err = strategy.Rollback(context.Background(), "m2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applied, _ = strategy.FindApplied(context.Background())
+	if len(applied) != 1 {
+		t.Errorf("expected 1 applied migration after rollback, got %v", len(applied))
+	}
+
+	err = strategy.Reset(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applied, _ = strategy.FindApplied(context.Background())
+	if len(applied) != 0 {
+		t.Errorf("expected no applied migrations after reset, got %v", len(applied))
+	}
+}
+
+func TestSQLiteTransaction(t *testing.T) {
+	config := shared.MigratorConfig{
+		DatabaseStrategyName: "sqlite",
+		DBConfig: shared.DatabaseConfig{
+			Database: filepath.Join(t.TempDir(), "csmig.db"),
+		},
+	}
+
+	strategy, err := GetPersistenceStrategy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer strategy.Close()
+
+	err = strategy.EnsureInfrastructure(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = strategy.Transaction(context.Background(), func(tx shared.DatabaseStrategy) error {
+		err := tx.Exec("CREATE TABLE widget (id INTEGER PRIMARY KEY);", nil)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Apply(context.Background(), shared.AppliedMigration{Name: "m1", Description: "first"})
+		if err != nil {
+			return err
+		}
+
+		//---fails the transaction as m1 was just recorded
+		return tx.Apply(context.Background(), shared.AppliedMigration{Name: "m1", Description: "duplicate"})
+	})
+	if err == nil {
+		t.Fatal("the transaction should have failed")
+	}
+
+	applied, _ := strategy.FindApplied(context.Background())
+	if len(applied) != 0 {
+		t.Errorf("the version record should have been rolled back, got %v", applied)
+	}
+
+	err = strategy.Exec("SELECT * FROM widget;", nil)
+	if err == nil {
+		t.Error("the widget table should have been rolled back")
+	}
+
+	err = strategy.Transaction(context.Background(), func(tx shared.DatabaseStrategy) error {
+		return tx.Apply(context.Background(), shared.AppliedMigration{Name: "m1", Description: "first"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applied, _ = strategy.FindApplied(context.Background())
+	if len(applied) != 1 {
+		t.Errorf("expected 1 applied migration after commit, got %v", len(applied))
+	}
+}
+
+func TestSQLiteLock(t *testing.T) {
+	config := shared.MigratorConfig{
+		DatabaseStrategyName: "sqlite",
+		DBConfig: shared.DatabaseConfig{
+			Database: filepath.Join(t.TempDir(), "csmig.db"),
+		},
+	}
+
+	first, _ := GetPersistenceStrategy(config)
+	defer first.Close()
+	second, _ := GetPersistenceStrategy(config)
+	defer second.Close()
+
+	err := first.Lock(context.Background(), time.Second, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//---both strategies run in this process, so the lock record is removed by force rather than by Unlock
+	err = second.Lock(context.Background(), 10*time.Millisecond, time.Hour)
+	if !errors.Is(err, shared.ErrLocked) {
+		t.Errorf("expected ErrLocked while the lock is held, got %v", err)
+	}
+
+	err = second.ForceUnlock(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = second.Lock(context.Background(), 10*time.Millisecond, time.Hour)
+	if err != nil {
+		t.Errorf("the lock should be free after ForceUnlock, got %v", err)
+	}
+
+	err = second.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = first.Lock(context.Background(), 10*time.Millisecond, time.Hour)
+	if err != nil {
+		t.Errorf("the lock should be free after Unlock, got %v", err)
+	}
+}
+
+func TestSQLiteUpgradeVersionTable(t *testing.T) {
+	config := shared.MigratorConfig{
+		DatabaseStrategyName: "sqlite",
+		DBConfig: shared.DatabaseConfig{
+			Database: filepath.Join(t.TempDir(), "csmig.db"),
+		},
+	}
+
+	strategy, err := GetPersistenceStrategy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer strategy.Close()
+
+	//---the version table as created by releases before checksums were recorded
+	err = strategy.Exec(`
+	CREATE TABLE csmig_versions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		applied_on DATETIME NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+		CONSTRAINT csmig_versions_name_unique UNIQUE (name)
+	);
+	INSERT INTO csmig_versions (name, description) VALUES ('m1', 'first');
+	`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = strategy.EnsureInfrastructure(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = strategy.Apply(context.Background(), shared.AppliedMigration{
+		Name:        "m2",
+		Description: "second",
+		Checksum:    "abc",
+		ExecutionMS: 42,
+		AppliedBy:   "deployer",
+		Hostname:    "build-01",
+		ToolVersion: "v9.9.9",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := strategy.FindApplied(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(applied) != 2 || applied[0].Checksum != "" || applied[1].Checksum != "abc" {
+		t.Fatalf("unexpected applied migrations after upgrade %v", applied)
+	}
+
+	m2 := applied[1]
+	if m2.ExecutionMS != 42 || m2.AppliedBy != "deployer" || m2.Hostname != "build-01" || m2.ToolVersion != "v9.9.9" {
+		t.Errorf("execution metadata was not recorded %v", m2)
+	}
+}
+
+func TestSQLiteFindAppliedReadOnly(t *testing.T) {
+	config := shared.MigratorConfig{
+		DatabaseStrategyName: "sqlite",
+		DBConfig: shared.DatabaseConfig{
+			Database: filepath.Join(t.TempDir(), "csmig.db"),
+		},
+	}
+
+	strategy, err := GetPersistenceStrategy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer strategy.Close()
+
+	db, release, err := GetSQLiteConnection(config.DBConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	columns := func() int {
+		var count int
+		err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('csmig_versions');`).Scan(&count)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return count
+	}
+
+	//---a database nothing has been applied to is left without a version table
+	applied, err := strategy.FindApplied(context.Background())
+	if err != nil || len(applied) != 0 {
+		t.Fatalf("expected no applied migrations, got %v (%v)", applied, err)
+	}
+
+	if columns() != 0 {
+		t.Error("FindApplied should not create the version table")
+	}
+
+	//---a table from an older release is read as it is, the upgrade is left to EnsureInfrastructure
+	_, err = db.Exec(`
+	CREATE TABLE csmig_versions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		applied_on DATETIME NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+		CONSTRAINT csmig_versions_name_unique UNIQUE (name)
+	);
+	INSERT INTO csmig_versions (name, description) VALUES ('m1', 'first');
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err = strategy.FindApplied(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(applied) != 1 || applied[0].Name != "m1" || applied[0].Description != "first" || applied[0].Dirty {
+		t.Errorf("unexpected applied migrations %v", applied)
+	}
+
+	if columns() != 4 {
+		t.Error("FindApplied should not upgrade the version table")
+	}
+}
+
+func TestSQLiteDirtyVersion(t *testing.T) {
+	config := shared.MigratorConfig{
+		DatabaseStrategyName: "sqlite",
+		DBConfig: shared.DatabaseConfig{
+			Database: filepath.Join(t.TempDir(), "csmig.db"),
+		},
+	}
+
+	strategy, err := GetPersistenceStrategy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer strategy.Close()
+
+	err = strategy.EnsureInfrastructure(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = strategy.Start(context.Background(), shared.AppliedMigration{Name: "m1", Description: "first"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applied, _ := strategy.FindApplied(context.Background())
+	if len(applied) != 1 || !applied[0].Dirty {
+		t.Fatalf("expected a dirty record after Start, got %v", applied)
+	}
+
+	err = strategy.Apply(context.Background(), shared.AppliedMigration{Name: "m1", Description: "first", ExecutionMS: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applied, _ = strategy.FindApplied(context.Background())
+	if len(applied) != 1 || applied[0].Dirty || applied[0].ExecutionMS != 7 {
+		t.Errorf("Apply should have completed the dirty record, got %v", applied)
+	}
+
+	err = strategy.Start(context.Background(), shared.AppliedMigration{Name: "m1", Description: "first"})
+	if err == nil {
+		t.Error("starting an applied migration should violate the unique index")
+	}
+}
+
+func TestSQLiteConnectionRelease(t *testing.T) {
+	config := shared.MigratorConfig{
+		DatabaseStrategyName: "sqlite",
+		DBConfig:             shared.DatabaseConfig{Database: filepath.Join(t.TempDir(), "csmig.db")},
+	}
+
+	strategy, err := GetPersistenceStrategy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = strategy.EnsureInfrastructure(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, release, err := GetSQLiteConnection(config.DBConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//---the connection outlives the strategy it is shared with
+	strategy.Close()
+
+	err = db.Ping()
+	if err != nil {
+		t.Fatalf("closing the strategy should leave the connection open: %v", err)
+	}
+
+	err = release()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if db.Ping() == nil {
+		t.Error("releasing the last hold on the pool should close it")
+	}
+
+	err = release()
+	if err != nil {
+		t.Error("releasing twice should do nothing")
+	}
+}
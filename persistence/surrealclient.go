@@ -0,0 +1,206 @@
+package persistence
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// surrealCloseTimeout how long Close waits to send the close message before dropping the connection.
+const surrealCloseTimeout = time.Second
+
+// errSurrealNotSent is returned for a request that couldn't be written because the connection had been lost, so
+// it never reached the server and is safe to send again.
+var errSurrealNotSent = errors.New("the request was not sent")
+
+// surrealClient is a connection to SurrealDB's RPC endpoint.  The driver's client retries failed reads until
+// gorilla panics and has no way to report a lost connection, so the strategy speaks the RPC protocol itself.
+// A failed read means the connection is lost: calls waiting on a response fail and the strategy reconnects.
+type surrealClient struct {
+	conn    *websocket.Conn
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	nextID  uint64
+	pending map[string]chan surrealResponse
+	err     error
+	lost    chan struct{}
+}
+
+type surrealRequest struct {
+	ID     string        `json:"id"`
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+}
+
+type surrealResponse struct {
+	ID     interface{}      `json:"id"`
+	Error  *surrealRPCError `json:"error,omitempty"`
+	Result interface{}      `json:"result,omitempty"`
+}
+
+// surrealRPCError an error reported by the server for a request.
+type surrealRPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *surrealRPCError) Error() string {
+	return e.Message
+}
+
+// dialSurrealClient opens a connection to the RPC endpoint at url.  Connecting gives up once ctx is done.
+func dialSurrealClient(ctx context.Context, url string) (*surrealClient, error) {
+	dialer := websocket.Dialer{Proxy: http.ProxyFromEnvironment}
+
+	conn, _, err := dialer.DialContext(ctx, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &surrealClient{
+		conn:    conn,
+		pending: map[string]chan surrealResponse{},
+		lost:    make(chan struct{}),
+	}
+
+	go c.readLoop()
+
+	return c, nil
+}
+
+// send sends an RPC request and waits for its response, giving up once ctx is done or the connection is lost.
+func (c *surrealClient) send(ctx context.Context, method string, params ...interface{}) (interface{}, error) {
+	response := make(chan surrealResponse, 1)
+
+	c.mu.Lock()
+	if c.err != nil {
+		err := c.err
+		c.mu.Unlock()
+
+		return nil, fmt.Errorf("%w: %w", errSurrealNotSent, err)
+	}
+
+	c.nextID++
+	id := strconv.FormatUint(c.nextID, 10)
+	c.pending[id] = response
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	err := c.write(ctx, surrealRequest{ID: id, Method: method, Params: params})
+	if err != nil {
+		//---a frame that was cut short is discarded by the server
+		c.fail(err)
+
+		return nil, fmt.Errorf("%w: %w", errSurrealNotSent, err)
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.lost:
+		//---a response may have arrived just before the connection was lost
+		select {
+		case r := <-response:
+			return r.result()
+		default:
+			return nil, fmt.Errorf("%w: %w", errSurrealConnectionLost, c.err)
+		}
+	case r := <-response:
+		return r.result()
+	}
+}
+
+// isLost reports whether the connection has been lost or closed.
+func (c *surrealClient) isLost() bool {
+	select {
+	case <-c.lost:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close sends a close message and closes the connection.  Calls still waiting on a response fail.
+func (c *surrealClient) Close() {
+	if c.isLost() {
+		return
+	}
+
+	message := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	c.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(surrealCloseTimeout))
+
+	c.fail(errors.New("the connection was closed"))
+}
+
+func (c *surrealClient) write(ctx context.Context, request surrealRequest) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	deadline, _ := ctx.Deadline()
+	c.conn.SetWriteDeadline(deadline)
+
+	return c.conn.WriteJSON(request)
+}
+
+// readLoop hands each response to the call waiting on it until a read fails, which ends the connection.
+func (c *surrealClient) readLoop() {
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			c.fail(err)
+			return
+		}
+
+		var r surrealResponse
+		if json.Unmarshal(data, &r) != nil {
+			continue
+		}
+
+		c.mu.Lock()
+		response, ok := c.pending[fmt.Sprintf("%v", r.ID)]
+		c.mu.Unlock()
+
+		//---the channel holds one response, a duplicate is dropped rather than blocking the loop
+		if ok {
+			select {
+			case response <- r:
+			default:
+			}
+		}
+	}
+}
+
+// fail marks the connection as lost with err, the first error to end it, and closes it.
+func (c *surrealClient) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return
+	}
+
+	c.err = err
+	close(c.lost)
+	c.conn.Close()
+}
+
+func (r surrealResponse) result() (interface{}, error) {
+	if r.Error != nil {
+		return nil, r.Error
+	}
+
+	return r.Result, nil
+}
@@ -1,153 +1,523 @@
 package persistence
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/cscoding21/csmig/shared"
 	"github.com/surrealdb/surrealdb.go"
 )
 
-var _conn *surrealdb.DB
-
-var SurrealDBStrategy = shared.DatabaseStrategy{
-	Name: "surrealdb",
-	DBConfig: shared.DatabaseConfig{
-		Name:      "surrealdb",
-		Host:      "localhost",
-		Port:      9999,
-		User:      "root",
-		Password:  "root",
-		Database:  "test",
-		Namespace: "test",
-	},
-	EnsureInfrastructure: func(config shared.DatabaseConfig) error {
-		db, err := GetSurrealConnection(config)
-		if err != nil {
-			panic(err)
-		}
+// errSurrealConnectionLost is returned for a query that was sent before the connection was lost.  Whether the
+// server ran it is unknown, so it isn't sent again.
+var errSurrealConnectionLost = fmt.Errorf("%w: the connection to surrealdb was lost", shared.ErrConnection)
 
-		defineSQL := fmt.Sprintf(`
-		DEFINE TABLE IF NOT EXISTS %s SCHEMAFULL;
-		DEFINE FIELD IF NOT EXISTS name ON TABLE %s TYPE string;
-		DEFINE FIELD IF NOT EXISTS description ON TABLE %s TYPE string;
-		DEFINE FIELD IF NOT EXISTS applied_on ON TABLE %s TYPE datetime DEFAULT time::now();
-		DEFINE INDEX %s_name_unique ON TABLE %s COLUMNS name UNIQUE;
-		`, VersionTableName, VersionTableName, VersionTableName, VersionTableName, VersionTableName, VersionTableName)
-		_, err = db.Query(defineSQL, nil)
-		if err != nil {
-			return err
-		}
+// surrealVersionIndex the unique index on the version table's name.
+const surrealVersionIndex = VersionTableName + "_name_unique"
+
+var (
+	surrealStartSQL = fmt.Sprintf(`
+	INSERT INTO %s (name, description, checksum, execution_ms, applied_by, hostname, tool_version, dirty)
+	VALUES ($name, $description, $checksum, $execution_ms, $applied_by, $hostname, $tool_version, true);`, VersionTableName)
+	surrealApplySQL = fmt.Sprintf(`
+	DELETE FROM %s WHERE name = $name AND dirty = true;
+	INSERT INTO %s (name, description, checksum, execution_ms, applied_by, hostname, tool_version, dirty)
+	VALUES ($name, $description, $checksum, $execution_ms, $applied_by, $hostname, $tool_version, false);`, VersionTableName, VersionTableName)
+	surrealRollbackSQL = fmt.Sprintf(`DELETE FROM %s where name = $name;`, VersionTableName)
+	surrealResetSQL    = fmt.Sprintf(`DELETE FROM %s;`, VersionTableName)
+
+	surrealStaleLockSQL   = fmt.Sprintf(`DELETE %s:lock WHERE locked_at < <datetime>$cutoff;`, LockTableName)
+	surrealLockSQL        = fmt.Sprintf(`CREATE %s:lock SET owner = $owner, locked_at = time::now();`, LockTableName)
+	surrealUnlockSQL      = fmt.Sprintf(`DELETE %s:lock WHERE owner = $owner;`, LockTableName)
+	surrealForceUnlockSQL = fmt.Sprintf(`DELETE %s:lock;`, LockTableName)
+)
+
+// surrealDBStrategy stores migration versions in a SurrealDB table.  Each strategy has its own connection,
+// opened on first use and closed by Close, so strategies for different namespaces never share a session.
+// It is safe for concurrent use.
+type surrealDBStrategy struct {
+	config shared.DatabaseConfig
+
+	mu     sync.Mutex
+	client *surrealClient
+}
+
+func init() {
+	Register("surrealdb", func(config shared.DatabaseConfig) (shared.DatabaseStrategy, error) {
+		return &surrealDBStrategy{config: config}, nil
+	})
+}
 
+// Name returns the name the strategy is registered under.
+func (s *surrealDBStrategy) Name() string {
+	return "surrealdb"
+}
+
+// EnsureInfrastructure defines the version table and its unique index if they don't exist.
+func (s *surrealDBStrategy) EnsureInfrastructure(ctx context.Context) error {
+	defineSQL := fmt.Sprintf(`
+	DEFINE TABLE IF NOT EXISTS %s SCHEMAFULL;
+	DEFINE FIELD IF NOT EXISTS name ON TABLE %s TYPE string;
+	DEFINE FIELD IF NOT EXISTS description ON TABLE %s TYPE string;
+	DEFINE FIELD IF NOT EXISTS applied_on ON TABLE %s TYPE datetime DEFAULT time::now();
+	DEFINE FIELD IF NOT EXISTS checksum ON TABLE %s TYPE string DEFAULT '';
+	DEFINE FIELD IF NOT EXISTS execution_ms ON TABLE %s TYPE int DEFAULT 0;
+	DEFINE FIELD IF NOT EXISTS applied_by ON TABLE %s TYPE string DEFAULT '';
+	DEFINE FIELD IF NOT EXISTS hostname ON TABLE %s TYPE string DEFAULT '';
+	DEFINE FIELD IF NOT EXISTS tool_version ON TABLE %s TYPE string DEFAULT '';
+	DEFINE FIELD IF NOT EXISTS dirty ON TABLE %s TYPE bool DEFAULT false;
+	DEFINE INDEX IF NOT EXISTS %s ON TABLE %s COLUMNS name UNIQUE;
+	`, VersionTableName, VersionTableName, VersionTableName, VersionTableName, VersionTableName, VersionTableName,
+		VersionTableName, VersionTableName, VersionTableName, VersionTableName, surrealVersionIndex, VersionTableName)
+
+	return s.exec(ctx, defineSQL, nil)
+}
+
+// Start records a dirty version record for a migration that is about to run.
+func (s *surrealDBStrategy) Start(ctx context.Context, migration shared.AppliedMigration) error {
+	return s.exec(ctx, surrealStartSQL, surrealApplyParams(migration))
+}
+
+// Apply records a migration as being applied, replacing its dirty record if there is one.
+func (s *surrealDBStrategy) Apply(ctx context.Context, migration shared.AppliedMigration) error {
+	return s.exec(ctx, surrealApplySQL, surrealApplyParams(migration))
+}
+
+// FindApplied returns all applied migrations in the order they were applied.
+func (s *surrealDBStrategy) FindApplied(ctx context.Context) ([]shared.AppliedMigration, error) {
+	applySQL := fmt.Sprintf(`SELECT * FROM %s ORDER BY applied_on ASC;`, VersionTableName)
+	migrationData, err := s.query(ctx, applySQL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	appliedMigraitons, err := surrealdb.SmartUnmarshal[[]shared.AppliedMigration](migrationData, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return appliedMigraitons, nil
+}
+
+// Rollback removes the applied record for a migration.
+func (s *surrealDBStrategy) Rollback(ctx context.Context, name string) error {
+	return s.exec(ctx, surrealRollbackSQL, map[string]interface{}{
+		"name": name,
+	})
+}
+
+// Reset removes all applied records.
+func (s *surrealDBStrategy) Reset(ctx context.Context) error {
+	return s.exec(ctx, surrealResetSQL, nil)
+}
+
+// Exec runs a SurrealQL statement with the given $params and a background context.
+func (s *surrealDBStrategy) Exec(sql string, params map[string]interface{}) error {
+	return s.exec(context.Background(), sql, params)
+}
+
+// ExecContext runs a SurrealQL statement with the given $params.
+func (s *surrealDBStrategy) ExecContext(ctx context.Context, sql string, params map[string]interface{}) error {
+	return s.exec(ctx, sql, params)
+}
+
+// TransactionalDDL reports true as DEFINE statements are buffered and committed together with the rest of the
+// transaction.
+func (s *surrealDBStrategy) TransactionalDDL() bool {
+	return true
+}
+
+// Transaction runs fn with a strategy that buffers every write.  SurrealDB only supports transactions
+// within a single query, so the buffered statements are sent together, wrapped in BEGIN and COMMIT
+// TRANSACTION, once fn returns nil.  Statement errors are therefore reported when the transaction commits.
+func (s *surrealDBStrategy) Transaction(ctx context.Context, fn func(tx shared.DatabaseStrategy) error) error {
+	tx := &surrealTxStrategy{parent: s, params: map[string]interface{}{}}
+
+	err := fn(tx)
+	if err != nil {
+		return err
+	}
+
+	if len(tx.statements) == 0 {
 		return nil
-	},
-	ApplyMigration: func(config shared.DatabaseConfig, name string, description string) error {
-		db, err := GetSurrealConnection(config)
-		if err != nil {
-			return err
-		}
+	}
+
+	query := "BEGIN TRANSACTION;\n" + strings.Join(tx.statements, "\n") + "\nCOMMIT TRANSACTION;"
+
+	return s.exec(ctx, query, tx.params)
+}
 
-		applySQL := fmt.Sprintf(`INSERT INTO %s (name, description) VALUES ($name, $description);`, VersionTableName)
+// Lock acquires the migration lock by creating the lock record.  SurrealDB has no advisory locks, so a
+// record left behind by a crashed process is taken over once it is older than staleAfter.
+func (s *surrealDBStrategy) Lock(ctx context.Context, timeout time.Duration, staleAfter time.Duration) error {
+	owner := lockOwner()
 
-		_, err = db.Query(applySQL, map[string]interface{}{
-			"name":        name,
-			"description": description,
+	return pollLock(ctx, timeout, func() (bool, error) {
+		resp, err := s.query(ctx, surrealStaleLockSQL, map[string]interface{}{
+			"cutoff": lockCutoff(staleAfter).UTC().Format(time.RFC3339Nano),
 		})
 		if err != nil {
-			return err
+			return false, err
 		}
 
-		return nil
-	},
-	FindAppliedMigrations: func(config shared.DatabaseConfig) ([]shared.AppliedMigration, error) {
-		db, err := GetSurrealConnection(config)
+		err = checkSurrealResponse(resp)
 		if err != nil {
-			return nil, err
+			return false, err
 		}
 
-		applySQL := fmt.Sprintf(`SELECT * FROM %s ORDER BY applied_on ASC;`, VersionTableName)
-		migrationData, err := db.Query(applySQL, nil)
+		resp, err = s.query(ctx, surrealLockSQL, map[string]interface{}{
+			"owner": owner,
+		})
 		if err != nil {
-			return nil, err
+			return false, err
 		}
 
-		appliedMigraitons, err := surrealdb.SmartUnmarshal[[]shared.AppliedMigration](migrationData, err)
-		if err != nil {
-			return nil, err
+		//---creating the record fails while another process holds the lock
+		err = checkSurrealResponse(resp)
+		if err != nil && strings.Contains(err.Error(), "already exists") {
+			return false, nil
 		}
 
-		return appliedMigraitons, nil
-	},
-	RollbackMigration: func(config shared.DatabaseConfig, name string) error {
-		db, err := GetSurrealConnection(config)
-		if err != nil {
-			return err
-		}
+		return err == nil, err
+	})
+}
 
-		applySQL := fmt.Sprintf(`DELETE FROM %s where name = $name;`, VersionTableName)
+// Unlock removes the lock record if this process created it.
+func (s *surrealDBStrategy) Unlock() error {
+	return s.exec(context.Background(), surrealUnlockSQL, map[string]interface{}{
+		"owner": lockOwner(),
+	})
+}
 
-		_, err = db.Query(applySQL, map[string]interface{}{
-			"name": name,
-		})
-		if err != nil {
-			return err
-		}
+// ForceUnlock removes the lock record regardless of who created it.
+func (s *surrealDBStrategy) ForceUnlock(ctx context.Context) error {
+	return s.exec(ctx, surrealForceUnlockSQL, nil)
+}
 
-		return nil
-	},
-	ResetMigrations: func(config shared.DatabaseConfig) error {
-		db, err := GetSurrealConnection(config)
-		if err != nil {
-			return err
-		}
+// Close closes the strategy's connection.  A later call on the strategy opens a new one.
+func (s *surrealDBStrategy) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-		applySQL := fmt.Sprintf(`DELETE FROM %s;`, VersionTableName)
+	if s.client != nil {
+		s.client.Close()
+		s.client = nil
+	}
 
-		_, err = db.Query(applySQL, nil)
-		if err != nil {
-			return err
-		}
+	return nil
+}
 
+// exec runs a query and returns the error of the first statement that failed.
+func (s *surrealDBStrategy) exec(ctx context.Context, sql string, params map[string]interface{}) error {
+	resp, err := s.query(ctx, sql, params)
+	if err != nil {
+		return err
+	}
+
+	return checkSurrealResponse(resp)
+}
+
+// query runs a query on the strategy's connection.  A connection that fails is dropped so that the next call
+// reconnects, and a query that couldn't be sent because the connection had been lost is sent again on a new one.
+func (s *surrealDBStrategy) query(ctx context.Context, sql string, vars interface{}) (interface{}, error) {
+	client, err := s.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.query(ctx, sql, vars)
+	if err == nil {
+		return resp, nil
+	}
+
+	//---after a timeout or a cancelled query the state of the connection is unknown, so it isn't reused
+	s.disconnect(client)
+	if !surrealNotSent(err) {
+		return nil, err
+	}
+
+	client, err = s.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err = client.query(ctx, sql, vars)
+	if err != nil {
+		s.disconnect(client)
+	}
+
+	return resp, err
+}
+
+// connect returns the strategy's connection, opening a new one if it has none or the last one was lost.
+func (s *surrealDBStrategy) connect(ctx context.Context) (*surrealClient, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.client != nil && !s.client.isLost() {
+		return s.client, nil
+	}
+
+	if s.client != nil {
+		s.client.Close()
+		s.client = nil
+	}
+
+	client, err := dialSurreal(ctx, s.config)
+	if err != nil {
+		return nil, err
+	}
+
+	s.client = client
+
+	return client, nil
+}
+
+// disconnect closes client if it is still the strategy's connection.
+func (s *surrealDBStrategy) disconnect(client *surrealClient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.client == client {
+		s.client.Close()
+		s.client = nil
+	}
+}
+
+// surrealTxStrategy collects the statements of a SurrealDB transaction until it is committed.
+type surrealTxStrategy struct {
+	parent     *surrealDBStrategy
+	statements []string
+	params     map[string]interface{}
+}
+
+// Name returns the name the strategy is registered under.
+func (t *surrealTxStrategy) Name() string {
+	return t.parent.Name()
+}
+
+// EnsureInfrastructure defines the version table immediately, outside of the transaction.
+func (t *surrealTxStrategy) EnsureInfrastructure(ctx context.Context) error {
+	return t.parent.EnsureInfrastructure(ctx)
+}
+
+// Start adds a dirty version record for a migration to the transaction.
+func (t *surrealTxStrategy) Start(ctx context.Context, migration shared.AppliedMigration) error {
+	return t.add(surrealStartSQL, surrealApplyParams(migration))
+}
+
+// Apply adds the version record for a migration to the transaction.
+func (t *surrealTxStrategy) Apply(ctx context.Context, migration shared.AppliedMigration) error {
+	return t.add(surrealApplySQL, surrealApplyParams(migration))
+}
+
+// FindApplied reads the applied migrations outside of the transaction.
+func (t *surrealTxStrategy) FindApplied(ctx context.Context) ([]shared.AppliedMigration, error) {
+	return t.parent.FindApplied(ctx)
+}
+
+// Rollback adds the removal of a migration's version record to the transaction.
+func (t *surrealTxStrategy) Rollback(ctx context.Context, name string) error {
+	return t.add(surrealRollbackSQL, map[string]interface{}{
+		"name": name,
+	})
+}
+
+// Reset adds the removal of all version records to the transaction.
+func (t *surrealTxStrategy) Reset(ctx context.Context) error {
+	return t.add(surrealResetSQL, nil)
+}
+
+// Exec adds a statement to the transaction.
+func (t *surrealTxStrategy) Exec(sql string, params map[string]interface{}) error {
+	return t.add(sql, params)
+}
+
+// ExecContext adds a statement to the transaction.  It runs with the context the transaction commits with.
+func (t *surrealTxStrategy) ExecContext(ctx context.Context, sql string, params map[string]interface{}) error {
+	return t.add(sql, params)
+}
+
+// TransactionalDDL reports whether the enclosing strategy rolls back schema changes.
+func (t *surrealTxStrategy) TransactionalDDL() bool {
+	return t.parent.TransactionalDDL()
+}
+
+// Transaction runs fn as part of the enclosing transaction.
+func (t *surrealTxStrategy) Transaction(ctx context.Context, fn func(tx shared.DatabaseStrategy) error) error {
+	return fn(t)
+}
+
+// Lock acquires the migration lock outside of the transaction.
+func (t *surrealTxStrategy) Lock(ctx context.Context, timeout time.Duration, staleAfter time.Duration) error {
+	return t.parent.Lock(ctx, timeout, staleAfter)
+}
+
+// Unlock releases the migration lock outside of the transaction.
+func (t *surrealTxStrategy) Unlock() error {
+	return t.parent.Unlock()
+}
+
+// ForceUnlock removes the lock record outside of the transaction.
+func (t *surrealTxStrategy) ForceUnlock(ctx context.Context) error {
+	return t.parent.ForceUnlock(ctx)
+}
+
+// Close is a no-op, the connection belongs to the parent strategy.
+func (t *surrealTxStrategy) Close() error {
+	return nil
+}
+
+// add buffers a statement.  Its params are renamed so that statements using the same names don't collide in the
+// combined query.
+func (t *surrealTxStrategy) add(statement string, params map[string]interface{}) error {
+	prefix := fmt.Sprintf("csmig_tx%d_", len(t.statements))
+	placeholder := func(position int) string {
+		return fmt.Sprintf("$%s%d", prefix, position)
+	}
+
+	//---unknown params are left alone as they may be SurrealDB's own, e.g. $value or $auth
+	rewritten, args, err := rewriteParams(statement, params, placeholder, false)
+	if err != nil {
+		return err
+	}
+
+	for i, arg := range args {
+		t.params[fmt.Sprintf("%s%d", prefix, i+1)] = arg
+	}
+
+	rewritten = strings.TrimSpace(rewritten)
+	if !strings.HasSuffix(rewritten, ";") {
+		rewritten += ";"
+	}
+
+	t.statements = append(t.statements, rewritten)
+
+	return nil
+}
+
+func surrealApplyParams(migration shared.AppliedMigration) map[string]interface{} {
+	return map[string]interface{}{
+		"name":         migration.Name,
+		"description":  migration.Description,
+		"checksum":     migration.Checksum,
+		"execution_ms": migration.ExecutionMS,
+		"applied_by":   migration.AppliedBy,
+		"hostname":     migration.Hostname,
+		"tool_version": migration.ToolVersion,
+	}
+}
+
+// checkSurrealResponse returns the error reported by the first failed statement in a query response.  A
+// version record that breaks the unique index on name is reported as ErrAlreadyApplied, whichever statement of a
+// failed transaction reports it.
+func checkSurrealResponse(resp interface{}) error {
+	results, ok := resp.([]interface{})
+	if !ok {
 		return nil
-	},
-	Exec: func(config shared.DatabaseConfig, sql string, params map[string]interface{}) error {
-		db, err := GetSurrealConnection(config)
-		if err != nil {
-			return err
+	}
+
+	var failed error
+	for _, r := range results {
+		result, ok := r.(map[string]interface{})
+		if !ok {
+			continue
 		}
 
-		_, err = db.Query(sql, params)
-		if err != nil {
-			return err
+		if status, _ := result["status"].(string); status != "" && status != "OK" {
+			detail := result["detail"]
+			if detail == nil {
+				detail = result["result"]
+			}
+
+			//---SurrealDB names the index in backticks when a record breaks it
+			if strings.Contains(fmt.Sprint(detail), "`"+surrealVersionIndex+"`") {
+				return fmt.Errorf("%w: %v", shared.ErrAlreadyApplied, detail)
+			}
+
+			if failed == nil {
+				failed = fmt.Errorf("surrealdb statement failed: %v", detail)
+			}
 		}
+	}
 
-		return nil
-	},
+	return failed
+}
+
+// surrealNotSent reports whether a query failed because the connection had been lost before it was sent, in
+// which case it never reached the server and is safe to send again.
+func surrealNotSent(err error) bool {
+	return errors.Is(err, errSurrealNotSent)
 }
 
+// dialSurreal opens a signed in connection to the namespace and database described by the config.
+func dialSurreal(ctx context.Context, config shared.DatabaseConfig) (*surrealClient, error) {
+	client, err := dialSurrealClient(ctx, surrealURL(config))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", shared.ErrConnection, err)
+	}
+
+	// Sign in
+	_, err = client.send(ctx, "signin", map[string]string{
+		"user": config.User,
+		"pass": config.Password,
+	})
+	if err == nil {
+		// Select namespace and database
+		_, err = client.send(ctx, "use", config.Namespace, config.Database)
+	}
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("%w: %w", shared.ErrConnection, err)
+	}
+
+	return client, nil
+}
+
+// query runs a query on the connection.
+func (c *surrealClient) query(ctx context.Context, sql string, vars interface{}) (interface{}, error) {
+	return c.send(ctx, "query", sql, vars)
+}
+
+// GetSurrealConnection opens a new, signed in connection to the namespace and database described by the
+// config.  The caller owns the connection and must close it.
 func GetSurrealConnection(config shared.DatabaseConfig) (*surrealdb.DB, error) {
-	if _conn != nil {
-		return _conn, nil
+	db, err := surrealdb.New(surrealURL(config))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", shared.ErrConnection, err)
 	}
 
-	db, err := surrealdb.New(fmt.Sprintf("ws://%s:%v/rpc", config.Host, config.Port))
+	err = surrealSignin(db, config)
 	if err != nil {
-		panic(err)
+		db.Close()
+		return nil, fmt.Errorf("%w: %w", shared.ErrConnection, err)
 	}
 
+	return db, nil
+}
+
+func surrealURL(config shared.DatabaseConfig) string {
+	return fmt.Sprintf("ws://%s:%v/rpc", config.Host, config.Port)
+}
+
+// surrealSignin signs in and selects the namespace and database.
+func surrealSignin(db *surrealdb.DB, config shared.DatabaseConfig) error {
 	// Sign in
-	if _, err = db.Signin(map[string]string{
+	if _, err := db.Signin(map[string]string{
 		"user": config.User,
 		"pass": config.Password,
 	}); err != nil {
-		return nil, err
+		return err
 	}
 
 	// Select namespace and database
-	if _, err = db.Use(config.Namespace, config.Database); err != nil {
-		return nil, err
+	if _, err := db.Use(config.Namespace, config.Database); err != nil {
+		return err
 	}
 
-	_conn = db
-
-	return _conn, nil
+	return nil
 }
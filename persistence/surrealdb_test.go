@@ -0,0 +1,330 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cscoding21/csmig/shared"
+	"github.com/gorilla/websocket"
+)
+
+// fakeSurreal is a websocket server answering the RPC methods the surrealdb strategy uses.
+type fakeSurreal struct {
+	server *httptest.Server
+
+	mu          sync.Mutex
+	connections int
+	namespaces  []string
+	queries     int
+	drop        func(conn *websocket.Conn, query int) bool
+
+	//---names with a version record, and the sign in and DEFINE failures to report if any
+	versions    map[string]bool
+	signinError string
+	defineError string
+}
+
+func newFakeSurreal(t *testing.T) *fakeSurreal {
+	f := &fakeSurreal{versions: map[string]bool{}}
+	upgrader := websocket.Upgrader{}
+
+	f.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		f.mu.Lock()
+		f.connections++
+		f.mu.Unlock()
+
+		for {
+			var req struct {
+				ID     interface{}   `json:"id"`
+				Method string        `json:"method"`
+				Params []interface{} `json:"params"`
+			}
+
+			if err := conn.ReadJSON(&req); err != nil {
+				return
+			}
+
+			var result interface{} = ""
+			var rpcErr interface{}
+			switch req.Method {
+			case "signin":
+				f.mu.Lock()
+				if f.signinError != "" {
+					rpcErr = map[string]interface{}{"code": -32000, "message": f.signinError}
+				}
+				f.mu.Unlock()
+			case "use":
+				f.mu.Lock()
+				f.namespaces = append(f.namespaces, req.Params[0].(string))
+				f.mu.Unlock()
+			case "query":
+				f.mu.Lock()
+				f.queries++
+				query, drop := f.queries, f.drop
+				f.mu.Unlock()
+
+				if drop != nil && drop(conn, query) {
+					return
+				}
+
+				result = []interface{}{f.versionResult(req.Params)}
+			}
+
+			response := map[string]interface{}{"id": req.ID, "result": result}
+			if rpcErr != nil {
+				response = map[string]interface{}{"id": req.ID, "error": rpcErr}
+			}
+
+			if err := conn.WriteJSON(response); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(f.server.Close)
+
+	return f
+}
+
+// versionResult answers a query, failing an insert into the version table the way the unique index on name does.
+func (f *fakeSurreal) versionResult(params []interface{}) interface{} {
+	sql, _ := params[0].(string)
+	vars, _ := params[1].(map[string]interface{})
+	name, _ := vars["name"].(string)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.defineError != "" && strings.Contains(sql, "DEFINE ") {
+		return map[string]interface{}{"status": "ERR", "detail": f.defineError}
+	}
+
+	if !strings.Contains(sql, "INSERT INTO "+VersionTableName) {
+		return map[string]interface{}{"status": "OK", "result": []interface{}{}}
+	}
+
+	if f.versions[name] {
+		return map[string]interface{}{
+			"status": "ERR",
+			"detail": fmt.Sprintf("Database index `%s` already contains '%s', with record `%s:1`", surrealVersionIndex, name, VersionTableName),
+		}
+	}
+
+	f.versions[name] = true
+
+	return map[string]interface{}{"status": "OK", "result": []interface{}{}}
+}
+
+func (f *fakeSurreal) strategy(t *testing.T, namespace string) shared.DatabaseStrategy {
+	addr := strings.TrimPrefix(f.server.URL, "http://")
+	host, port, _ := strings.Cut(addr, ":")
+	portNumber, _ := strconv.Atoi(port)
+
+	strategy, err := GetPersistenceStrategy(shared.MigratorConfig{
+		DatabaseStrategyName: "surrealdb",
+		DBConfig:             shared.DatabaseConfig{Host: host, Port: portNumber, Namespace: namespace, Database: "test"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { strategy.Close() })
+
+	return strategy
+}
+
+func (f *fakeSurreal) counts() (int, []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.connections, append([]string(nil), f.namespaces...)
+}
+
+func TestSurrealDBConnections(t *testing.T) {
+	f := newFakeSurreal(t)
+	ctx := context.Background()
+
+	first := f.strategy(t, "first")
+	second := f.strategy(t, "second")
+
+	//---concurrent calls share the strategy's connection
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := first.FindApplied(ctx); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if err := second.ExecContext(ctx, "SELECT * FROM widget;", nil); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	//---connections are dialed without touching gorilla's process wide dialer
+	if websocket.DefaultDialer.NetDialContext != nil {
+		t.Error("the default websocket dialer should be left alone")
+	}
+
+	connections, namespaces := f.counts()
+	if connections != 2 || len(namespaces) != 2 || !strings.Contains(strings.Join(namespaces, ","), "first") ||
+		!strings.Contains(strings.Join(namespaces, ","), "second") {
+		t.Errorf("expected a connection per strategy, got %d connections using %v", connections, namespaces)
+	}
+
+	//---closing one strategy leaves the other connected, and the closed one reconnects on its next call
+	err := first.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = second.ExecContext(ctx, "SELECT * FROM widget;", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = first.FindApplied(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	connections, namespaces = f.counts()
+	if connections != 3 || namespaces[2] != "first" {
+		t.Errorf("expected the closed strategy to reconnect, got %d connections using %v", connections, namespaces)
+	}
+
+	//---recording a migration twice fails like it does for the other strategies
+	am := shared.AppliedMigration{Name: "m1", Description: "first"}
+	err = first.Apply(ctx, am)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, record := range []func(context.Context, shared.AppliedMigration) error{first.Apply, first.Start} {
+		err = record(ctx, am)
+		if !errors.Is(err, shared.ErrAlreadyApplied) || shared.ExitCode(err) != shared.ExitAlreadyApplied {
+			t.Errorf("expected ErrAlreadyApplied for a recorded migration, got %v", err)
+		}
+	}
+
+	//---a failed DEFINE is reported rather than surfacing later as a failed Apply
+	err = first.EnsureInfrastructure(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.mu.Lock()
+	f.defineError = "IAM error: Not enough permissions to perform this action"
+	f.mu.Unlock()
+
+	err = first.EnsureInfrastructure(ctx)
+	if err == nil || !strings.Contains(err.Error(), "Not enough permissions") {
+		t.Errorf("expected the failed DEFINE to be reported, got %v", err)
+	}
+
+	err = first.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = first.Close()
+	if err != nil {
+		t.Error("closing a closed strategy should do nothing")
+	}
+
+	//---a rejected sign in is a connection failure
+	f.mu.Lock()
+	f.signinError = "There was a problem with authentication"
+	f.mu.Unlock()
+
+	_, err = first.FindApplied(ctx)
+	if !errors.Is(err, shared.ErrConnection) || shared.ExitCode(err) != shared.ExitConnection {
+		t.Errorf("expected ErrConnection for a rejected sign in, got %v", err)
+	}
+}
+
+func TestSurrealDBReconnect(t *testing.T) {
+	tests := []struct {
+		name string
+		drop func(conn *websocket.Conn)
+	}{
+		{
+			name: "close frame",
+			drop: func(conn *websocket.Conn) {
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""))
+			},
+		},
+		{
+			name: "dropped connection",
+			drop: func(conn *websocket.Conn) {
+				conn.UnderlyingConn().Close()
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeSurreal(t)
+			ctx := context.Background()
+			strategy := f.strategy(t, "test")
+
+			err := strategy.ExecContext(ctx, "SELECT * FROM widget;", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			//---the server drops the connection while the second query waits for its response
+			f.mu.Lock()
+			f.drop = func(conn *websocket.Conn, query int) bool {
+				if query != 2 {
+					return false
+				}
+
+				tt.drop(conn)
+
+				return true
+			}
+			f.mu.Unlock()
+
+			done := make(chan error, 1)
+			go func() { done <- strategy.ExecContext(ctx, "SELECT * FROM widget;", nil) }()
+
+			select {
+			case err = <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("a query on a lost connection should fail")
+			}
+
+			if !errors.Is(err, errSurrealConnectionLost) {
+				t.Errorf("expected the connection to be lost, got %v", err)
+			}
+
+			//---the next query is sent on a new connection
+			err = strategy.ExecContext(ctx, "SELECT * FROM widget;", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if connections, _ := f.counts(); connections != 2 {
+				t.Errorf("expected the strategy to reconnect once, got %d connections", connections)
+			}
+		})
+	}
+}
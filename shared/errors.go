@@ -0,0 +1,106 @@
+package shared
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrLocked is returned when the migration lock is held by another process.
+	ErrLocked = errors.New("the migration lock is held by another process")
+
+	// ErrConnection is returned when the database can't be reached or the connection to it is lost.
+	ErrConnection = errors.New("database connection failed")
+
+	// ErrMigrationFailed is matched by the MigrationError returned when a migration's Up or Down method fails.
+	ErrMigrationFailed = errors.New("migration failed")
+
+	// ErrAlreadyApplied is returned when a migration that has already been applied is recorded or removed.
+	ErrAlreadyApplied = errors.New("the migration has already been applied")
+
+	// ErrDirty is returned when a migration failed part way through and hasn't been resolved.
+	ErrDirty = errors.New("migration is dirty")
+
+	// ErrOutOfDate is returned by status when migrations are pending or applied migrations are orphaned.
+	ErrOutOfDate = errors.New("the database is not up to date with the migrations")
+)
+
+// MigrationError is returned when a migration fails.  It matches ErrMigrationFailed with errors.Is and
+// unwraps to the error returned by the migration.
+type MigrationError struct {
+	Name      string
+	Direction Direction
+	Dirty     bool
+	Err       error
+}
+
+// Error return a message naming the failed migration.
+func (e *MigrationError) Error() string {
+	if e.Dirty {
+		return fmt.Sprintf("migration %s failed and has been marked dirty: %v", e.Name, e.Err)
+	}
+
+	return fmt.Sprintf("migration %s failed: %v", e.Name, e.Err)
+}
+
+// Unwrap return the error returned by the migration.
+func (e *MigrationError) Unwrap() error {
+	return e.Err
+}
+
+// Is report whether target is ErrMigrationFailed.
+func (e *MigrationError) Is(target error) bool {
+	return target == ErrMigrationFailed
+}
+
+// Exit codes of the csmig CLI and the generated runner, so that scripts can tell failures apart.
+const (
+	ExitError           = 1
+	ExitUsage           = 2
+	ExitConnection      = 3
+	ExitMigrationFailed = 4
+	ExitLocked          = 5
+	ExitDirty           = 6
+	ExitAlreadyApplied  = 7
+	ExitOutOfDate       = 8
+)
+
+// exitCodes the typed errors with their exit codes, most specific first.  A migration that failed because the
+// connection was lost is reported as a failed migration.
+var exitCodes = []struct {
+	code int
+	err  error
+}{
+	{code: ExitMigrationFailed, err: ErrMigrationFailed},
+	{code: ExitLocked, err: ErrLocked},
+	{code: ExitDirty, err: ErrDirty},
+	{code: ExitAlreadyApplied, err: ErrAlreadyApplied},
+	{code: ExitConnection, err: ErrConnection},
+	{code: ExitOutOfDate, err: ErrOutOfDate},
+}
+
+// ExitCode return the exit code for an error: 0 for nil, the code of the typed error it wraps or ExitError.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	for _, ec := range exitCodes {
+		if errors.Is(err, ec.err) {
+			return ec.code
+		}
+	}
+
+	return ExitError
+}
+
+// ExitCodeError return the typed error an exit code stands for, or nil if it doesn't stand for one.
+func ExitCodeError(code int) error {
+	for _, ec := range exitCodes {
+		if ec.code == code {
+			return ec.err
+		}
+	}
+
+	return nil
+}
@@ -1,17 +1,55 @@
 package shared
 
 import (
+	"context"
+	"fmt"
+	"os"
 	"time"
 )
 
 // Migration represents a single migration.
 type Migration struct {
-	FilePath    string `yaml:"file_path"`
-	Package     string `yaml:"package"`
-	Name        string `yaml:"name"`
-	Description string `yaml:"description"`
-	Up          func(DatabaseStrategy) error
-	Down        func(DatabaseStrategy) error
+	FilePath    string                       `yaml:"file_path"`
+	Package     string                       `yaml:"package"`
+	Name        string                       `yaml:"name"`
+	Description string                       `yaml:"description"`
+	Up          func(DatabaseStrategy) error `yaml:"-" json:"-"`
+	Down        func(DatabaseStrategy) error `yaml:"-" json:"-"`
+
+	//---migrations that can't run inside a transaction, e.g. CREATE INDEX CONCURRENTLY, opt out here
+	NoTransaction bool `yaml:"no_transaction"`
+
+	//---sha256 of the migration's source, recorded when it is applied to detect later edits
+	Checksum string `yaml:"checksum"`
+
+	//---context aware versions of Up and Down, used in their place when set
+	UpContext   func(context.Context, DatabaseStrategy) error `yaml:"-" json:"-"`
+	DownContext func(context.Context, DatabaseStrategy) error `yaml:"-" json:"-"`
+}
+
+// RunUp run the migration's "UpContext" method, or its "Up" method for migrations written before contexts
+// were supported.  Either way, Exec calls on the strategy they receive honour ctx.
+func (m Migration) RunUp(ctx context.Context, ds DatabaseStrategy) error {
+	return m.run(ctx, ds, m.UpContext, m.Up, "Up")
+}
+
+// RunDown run the migration's "DownContext" method, or its "Down" method for migrations written before
+// contexts were supported.  Either way, Exec calls on the strategy they receive honour ctx.
+func (m Migration) RunDown(ctx context.Context, ds DatabaseStrategy) error {
+	return m.run(ctx, ds, m.DownContext, m.Down, "Down")
+}
+
+func (m Migration) run(ctx context.Context, ds DatabaseStrategy, withContext func(context.Context, DatabaseStrategy) error, legacy func(DatabaseStrategy) error, method string) error {
+	ds = WithContext(ctx, ds)
+
+	switch {
+	case withContext != nil:
+		return withContext(ctx, ds)
+	case legacy != nil:
+		return legacy(ds)
+	default:
+		return fmt.Errorf("migration %s has no %s method", m.Name, method)
+	}
 }
 
 // AppliedMigration represents a migration that has been applied to the database.
@@ -19,6 +57,46 @@ type AppliedMigration struct {
 	Name        string    `json:"name"`
 	Description string    `json:"description"`
 	AppliedOn   time.Time `json:"applied_on"`
+	Checksum    string    `json:"checksum"`
+
+	//---set while the migration is running, a dirty record left behind means it failed part way through
+	Dirty bool `json:"dirty"`
+
+	//---execution metadata recorded for auditing
+	ExecutionMS int64  `json:"execution_ms"`
+	AppliedBy   string `json:"applied_by"`
+	Hostname    string `json:"hostname"`
+	ToolVersion string `json:"tool_version"`
+}
+
+// ChecksumMismatch an applied migration whose source has changed since it was applied.
+type ChecksumMismatch struct {
+	Name     string `json:"name"`
+	Recorded string `json:"recorded"`
+	Current  string `json:"current"`
+}
+
+// Direction the direction in which a migration runs.
+type Direction string
+
+const (
+	DirectionUp   Direction = "up"
+	DirectionDown Direction = "down"
+)
+
+// PlanStep describes a migration that would be run by Apply or Rollback.
+type PlanStep struct {
+	Name        string             `json:"name"`
+	Description string             `json:"description"`
+	Direction   Direction          `json:"direction"`
+	Statements  []PlannedStatement `json:"statements"`
+	Warning     string             `json:"warning,omitempty"`
+}
+
+// PlannedStatement a statement captured from Exec while planning a migration.
+type PlannedStatement struct {
+	Statement string                 `json:"statement"`
+	Params    map[string]interface{} `json:"params,omitempty"`
 }
 
 // Manifest strongly typed respresentation of the manifest file.
@@ -29,6 +107,14 @@ type MigratorConfig struct {
 	ImplementationName   string         `yaml:"implementation_name"`
 	DatabaseStrategyName string         `yaml:"database_strategy_name"`
 	DBConfig             DatabaseConfig `yaml:"database_strategy"`
+	Lock                 LockConfig     `yaml:"lock"`
+
+	//---how long a whole run and each migration within it may take, zero means no limit
+	Timeout          time.Duration `yaml:"timeout"`
+	MigrationTimeout time.Duration `yaml:"migration_timeout"`
+
+	//---shell commands run around CLI runs
+	Hooks HookCommands `yaml:"hooks"`
 
 	Migrations []Migration `yaml:"migrations"`
 }
@@ -42,18 +128,80 @@ type DatabaseConfig struct {
 	Password  string `yaml:"password"`
 	Database  string `yaml:"database"`
 	Namespace string `yaml:"namespace"`
+
+	//---driver specific connection options (e.g. sslmode for postgres)
+	Options map[string]string `yaml:"options"`
+}
+
+// LockConfig controls how long a run waits for the migration lock.
+type LockConfig struct {
+	//---how long to wait for another process to release the lock, zero uses the strategy default
+	Timeout time.Duration `yaml:"timeout"`
+
+	//---age after which a lock record is treated as abandoned and taken over, zero uses the strategy default.
+	//---advisory locks are released by the database when their session ends, so this only applies to lock records
+	StaleAfter time.Duration `yaml:"stale_after"`
+}
+
+// DatabaseStrategy defines the interface a persistence backend implements to store migration versions.
+type DatabaseStrategy interface {
+	// Name returns the name the strategy is registered under.
+	Name() string
+	// EnsureInfrastructure creates the version table in the target database if it doesn't exist.
+	EnsureInfrastructure(ctx context.Context) error
+	// Start records that a migration is about to run.  The record is dirty until Apply completes it.
+	Start(ctx context.Context, migration AppliedMigration) error
+	// Apply records a migration as being applied, replacing the dirty record written by Start if there is
+	// one.  AppliedOn is set by the strategy.
+	Apply(ctx context.Context, migration AppliedMigration) error
+	// FindApplied returns all applied migrations in the order they were applied.
+	FindApplied(ctx context.Context) ([]AppliedMigration, error)
+	// Rollback removes the applied record for a migration.
+	Rollback(ctx context.Context, name string) error
+	// Reset removes all applied records.
+	Reset(ctx context.Context) error
+	// Exec runs a statement against the target database, binding the named $params.  It is ExecContext
+	// with a background context, except on the strategy handed to a migration where it uses the run's context.
+	Exec(statement string, params map[string]interface{}) error
+	// ExecContext runs a statement against the target database, binding the named $params.
+	ExecContext(ctx context.Context, statement string, params map[string]interface{}) error
+	// Transaction runs fn with a strategy whose calls all take part in one transaction.  The
+	// transaction is committed when fn returns nil and rolled back otherwise.
+	Transaction(ctx context.Context, fn func(tx DatabaseStrategy) error) error
+	// TransactionalDDL reports whether schema changes are rolled back with a transaction.  When they aren't,
+	// every migration is recorded as dirty while it runs.
+	TransactionalDDL() bool
+	// Lock acquires the migration lock, waiting up to timeout for another process to release it.  A lock
+	// record older than staleAfter is taken over.  ErrLocked is returned if the lock can't be acquired.
+	Lock(ctx context.Context, timeout time.Duration, staleAfter time.Duration) error
+	// Unlock releases the migration lock held by this strategy.  It takes no context so that the lock is
+	// still released after a run is cancelled.
+	Unlock() error
+	// ForceUnlock releases the migration lock regardless of who holds it.
+	ForceUnlock(ctx context.Context) error
+	// Close releases the resources held by the strategy.
+	Close() error
+}
+
+// WithContext return a strategy whose Exec method runs with ctx.  It lets migrations written before
+// contexts were supported be cancelled.
+func WithContext(ctx context.Context, ds DatabaseStrategy) DatabaseStrategy {
+	if c, ok := ds.(contextStrategy); ok {
+		ds = c.DatabaseStrategy
+	}
+
+	return contextStrategy{DatabaseStrategy: ds, ctx: ctx}
+}
+
+// contextStrategy binds Exec to a context.
+type contextStrategy struct {
+	DatabaseStrategy
+	ctx context.Context
 }
 
-// DatabaseStrategy defines the interface for a database strategy.
-type DatabaseStrategy struct {
-	Name                  string
-	DBConfig              DatabaseConfig
-	EnsureInfrastructure  func(DatabaseConfig) error
-	ApplyMigration        func(DatabaseConfig, string, string) error
-	FindAppliedMigrations func(DatabaseConfig) ([]AppliedMigration, error)
-	RollbackMigration     func(DatabaseConfig, string) error
-	ResetMigrations       func(DatabaseConfig) error
-	Exec                  func(DatabaseConfig, string, map[string]interface{}) error
+// Exec runs a statement with the bound context.
+func (s contextStrategy) Exec(statement string, params map[string]interface{}) error {
+	return s.DatabaseStrategy.ExecContext(s.ctx, statement, params)
 }
 
 // GetMigrationPath return the path that migrations will be stored based on properties in the manifest object.
@@ -62,22 +210,92 @@ func (manifest *MigratorConfig) GetMigrationPath() string {
 	return manifest.GeneratorPath
 }
 
-// GetTestConfig returns a test config object to support unit tests.
+// GetTestConfig returns a test config object to support unit tests.  Tests run against an in-memory
+// SQLite database unless the CSMIG_TEST_STRATEGY environment variable names another strategy.
 func GetTestConfig() MigratorConfig {
+	strategyName := os.Getenv("CSMIG_TEST_STRATEGY")
+	if strategyName == "" {
+		strategyName = "sqlite"
+	}
+
 	config := MigratorConfig{
 		GeneratorPath:        "migrations",
 		GeneratorPackage:     "migrations",
-		DatabaseStrategyName: "surrealdb",
-		DBConfig: DatabaseConfig{
-			Name:      "surrealdb",
-			Host:      "localhost",
-			Port:      9999,
-			User:      "root",
-			Password:  "root",
-			Database:  "test",
-			Namespace: "test",
-		},
+		DatabaseStrategyName: strategyName,
+		DBConfig:             testDatabaseConfigs[strategyName],
 	}
 
 	return config
 }
+
+var testDatabaseConfigs = map[string]DatabaseConfig{
+	"surrealdb": {
+		Name:      "surrealdb",
+		Host:      "localhost",
+		Port:      9999,
+		User:      "root",
+		Password:  "root",
+		Database:  "test",
+		Namespace: "test",
+	},
+	"postgres": {
+		Name:      "postgres",
+		Host:      "localhost",
+		Port:      5432,
+		User:      "postgres",
+		Password:  "postgres",
+		Database:  "test",
+		Namespace: "public",
+	},
+	"mysql": {
+		Name:     "mysql",
+		Host:     "localhost",
+		Port:     3306,
+		User:     "root",
+		Password: "root",
+		Database: "test",
+	},
+	"sqlite": {
+		Name:     "sqlite",
+		Database: ":memory:",
+	},
+	"memory": {
+		Name:     "memory",
+		Database: "test",
+	},
+}
+
+// HookEvent the point in a run at which a hook is called.
+type HookEvent string
+
+// The points in a run at which a Migrator calls hooks.
+const (
+	HookBeforeAll  HookEvent = "before_all"
+	HookAfterAll   HookEvent = "after_all"
+	HookBeforeEach HookEvent = "before_each"
+	HookAfterEach  HookEvent = "after_each"
+	HookOnError    HookEvent = "on_error"
+)
+
+// Hook is called by a Migrator around a run and around each migration in it, with the migration, the direction
+// it runs in and the strategy the run uses.  BeforeAll and AfterAll hooks receive the first and last migration
+// of the run.  An error stops the run.
+type Hook func(ctx context.Context, migration Migration, direction Direction, ds DatabaseStrategy) error
+
+// ErrorHook is called by a Migrator when a run fails, with the migration that was running and the error that
+// stopped it.
+type ErrorHook func(ctx context.Context, migration Migration, direction Direction, ds DatabaseStrategy, err error) error
+
+// HookCommands shell commands the CLI runs around a run and around each migration in it.  Each list runs in
+// order, and a command that fails stops the run like any other hook.
+type HookCommands struct {
+	BeforeAll  []string `yaml:"before_all"`
+	AfterAll   []string `yaml:"after_all"`
+	BeforeEach []string `yaml:"before_each"`
+	AfterEach  []string `yaml:"after_each"`
+	OnError    []string `yaml:"on_error"`
+}
+
+// RunConfigEnvVar the environment variable naming the file the CLI hands the resolved config to the generated
+// entrypoint in.  Hook commands don't inherit it.
+const RunConfigEnvVar = "CSMIG_RUN_CONFIG"